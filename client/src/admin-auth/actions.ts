@@ -23,10 +23,32 @@ export const signIn = (
                 credentials,
             ),
         })
+            .then(res => {
+                // Admins with two-factor authentication get a pending session,
+                // which is completed with a code from their authenticator app
+                // or a recovery code.
+                if (res.value.status === 202) {
+                    const code = window.prompt(
+                        'Enter the code from your authenticator app, or a recovery code',
+                    );
+                    const secondFactor =
+                        code && /^\d{6}$/.test(code.replace(/\s/g, ''))
+                            ? { code }
+                            : { recoveryCode: code };
+                    return axios.post(
+                        `https://${getCurrentHost()}/v1/sessions/totp`,
+                        {
+                            pendingToken: res.value.data.pendingToken,
+                            ...secondFactor,
+                        },
+                    );
+                }
+                return res.value;
+            })
             .then(res => {
                 // Hide error if this asyc call is successful.
                 dispatch(hideError());
-                const sessionToken = res.value.headers.authorization;
+                const sessionToken = res.headers.authorization;
                 storeSessionToken(sessionToken, history);
             })
             .catch(error => {
@@ -132,10 +132,10 @@ class AdminAuth extends React.Component<any, {}> {
                 label: 'Confirm Your Password',
             },
             {
-                type: 'password',
-                ref: 'key',
+                type: 'text',
+                ref: 'invitationCode',
                 isRequired: true,
-                label: 'Key',
+                label: 'Invitation Code',
             },
         ];
 
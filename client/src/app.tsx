@@ -4,6 +4,7 @@ import { Provider } from 'react-redux';
 import { BrowserRouter, Route, Switch } from 'react-router-dom';
 
 import store from 'store';
+import { configureAxios } from 'utils';
 
 // Shared style
 import 'stylesheets/shared';
@@ -15,6 +16,8 @@ import Dashboard from 'dashboard';
 import ThankYou from 'thank-you';
 import NotFound from 'not-found';
 
+configureAxios();
+
 class App extends React.Component<any, any> {
     public render() {
         return (
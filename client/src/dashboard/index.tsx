@@ -1,4 +1,5 @@
 import * as React from 'react';
+import axios from 'axios';
 import { connect } from 'react-redux';
 import { Switch, Route, Redirect } from 'react-router-dom';
 
@@ -64,9 +65,26 @@ class Dashboard extends React.Component<any, any> {
         this.establishWebsocket();
     }
 
-    private establishWebsocket = (): WebSocket => {
+    // Exchange the session token for a short-lived, single-use ticket,
+    // so that the session token never ends up in a URL.
+    private establishWebsocket = (): Promise<WebSocket | void> => {
+        return axios
+            .post(`https://${getCurrentHost()}/v1/ws/tickets`, null, {
+                headers: {
+                    Authorization: getSessionToken(),
+                },
+            })
+            .then(res => this.openWebsocket(res.data.ticket))
+            .catch(error => {
+                console.log(error);
+            });
+    };
+
+    private openWebsocket = (ticket: string): WebSocket => {
         const websocket = new WebSocket(
-            `wss://${getCurrentHost()}/v1/ws?auth=${getSessionToken()}`,
+            `wss://${getCurrentHost()}/v1/ws?ticket=${encodeURIComponent(
+                ticket,
+            )}`,
         );
         websocket.addEventListener('error', function(error) {
             console.log(error);
@@ -1,3 +1,5 @@
+import axios from 'axios';
+
 export const getCurrentHost = (): string => {
     if (window.location.hostname === 'visitorex.zicodeng.me') {
         return 'visitorex-api.zicodeng.me';
@@ -6,6 +8,54 @@ export const getCurrentHost = (): string => {
 };
 
 export const SESSION_TOKEN_STORAGE_KEY = 'session-token';
+export const CSRF_TOKEN_STORAGE_KEY = 'csrf-token';
+const CSRF_COOKIE_NAME = 'csrf';
+
+// When the gateway keeps sessions in cookies,
+// no Authorization header is sent back,
+// so this placeholder marks the admin as signed in.
+const COOKIE_SESSION = 'cookie';
+
+// Whether the gateway keeps sessions in cookies rather than
+// sending session tokens in the Authorization header.
+// Credentialed requests need the gateway to allow this client's origin,
+// so they are only made in cookie mode.
+const COOKIE_TRANSPORT = process.env.SESSION_TRANSPORT === 'cookie';
+
+// In cookie mode, send cookies with every request to the gateway,
+// and echo the CSRF token, so that cookie-based sessions work.
+export const configureAxios = (): void => {
+    if (!COOKIE_TRANSPORT) {
+        return;
+    }
+    axios.defaults.withCredentials = true;
+    axios.interceptors.response.use(res => {
+        const csrfToken = res.headers['x-csrf-token'];
+        if (csrfToken) {
+            localStorage.setItem(CSRF_TOKEN_STORAGE_KEY, csrfToken);
+        }
+        return res;
+    });
+    axios.interceptors.request.use(config => {
+        const csrfToken = getCSRFToken();
+        if (csrfToken) {
+            config.headers['X-CSRF-Token'] = csrfToken;
+        }
+        return config;
+    });
+};
+
+// Get the CSRF token from its cookie if it is readable,
+// or from the one last sent by the gateway.
+const getCSRFToken = (): string | null => {
+    const cookie = document.cookie
+        .split('; ')
+        .find(c => c.startsWith(`${CSRF_COOKIE_NAME}=`));
+    if (cookie) {
+        return cookie.substring(CSRF_COOKIE_NAME.length + 1);
+    }
+    return localStorage.getItem(CSRF_TOKEN_STORAGE_KEY);
+};
 
 // Get session token from local storage.
 export const getSessionToken = (): String | null => {
@@ -20,13 +70,17 @@ export const getSessionToken = (): String | null => {
 
 // Store session token to local storage.
 export const storeSessionToken = (sessionToken: string, history): void => {
-    localStorage.setItem(SESSION_TOKEN_STORAGE_KEY, sessionToken);
+    localStorage.setItem(
+        SESSION_TOKEN_STORAGE_KEY,
+        sessionToken || COOKIE_SESSION,
+    );
     history.push('/dashboard/overview');
 };
 
 // Remove session token in local storage.
 export const removeSessionToken = (): void => {
     localStorage.removeItem(SESSION_TOKEN_STORAGE_KEY);
+    localStorage.removeItem(CSRF_TOKEN_STORAGE_KEY);
     window.location.replace('/');
 };
 
@@ -13,4 +13,13 @@ module.exports = merge(common, {
         historyApiFallback: true,
         compress: true,
     },
+    plugins: [
+        // Inject how the gateway carries session tokens,
+        // which must match its SESSION_TRANSPORT.
+        new webpack.DefinePlugin({
+            'process.env.SESSION_TRANSPORT': JSON.stringify(
+                process.env.SESSION_TRANSPORT || 'header',
+            ),
+        }),
+    ],
 });
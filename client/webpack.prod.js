@@ -6,10 +6,15 @@ var UglifyJsPlugin = require('uglifyjs-webpack-plugin');
 module.exports = merge(common, {
     devtool: 'source-map',
     plugins: [
-        // Inject production as NODE_ENV in webpack build process.
+        // Inject production as NODE_ENV in webpack build process,
+        // along with how the gateway carries session tokens,
+        // which must match its SESSION_TRANSPORT.
         new webpack.DefinePlugin({
             'process.env': {
                 NODE_ENV: JSON.stringify('production'),
+                SESSION_TRANSPORT: JSON.stringify(
+                    process.env.SESSION_TRANSPORT || 'header',
+                ),
             },
         }),
         // Webpack built-in UglifyJsPlugin doesn't work with webpack-dev-server version 2.8.0+
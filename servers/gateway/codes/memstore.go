@@ -0,0 +1,96 @@
+package codes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memEntry is a code entry kept by MemStore.
+type memEntry struct {
+	// JSON-encoded value,
+	// so that callers never share memory with the store.
+	value []byte
+	// Time after which this entry is considered expired.
+	expiry time.Time
+}
+
+// MemStore represents a codes.Store backed by a concurrent in-memory map.
+// It is useful for local development and tests where no Redis server is available.
+type MemStore struct {
+	entries map[string]*memEntry
+	mx      sync.Mutex
+}
+
+// NewMemStore constructs a new MemStore.
+// Expired entries are evicted by a background goroutine
+// every "sweepInterval".
+func NewMemStore(sweepInterval time.Duration) *MemStore {
+	if sweepInterval <= 0 {
+		panic("Sweep interval must be positive")
+	}
+
+	memStore := &MemStore{
+		entries: make(map[string]*memEntry),
+	}
+	go memStore.sweep(sweepInterval)
+	return memStore
+}
+
+// Store implementation
+
+// Save saves the provided "value" and associated code to the store.
+// The code expires after "ttl" if it is not consumed.
+func (ms *MemStore) Save(code string, value interface{}, ttl time.Duration) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error marshalling struct to JSON: %v", err)
+	}
+
+	ms.mx.Lock()
+	ms.entries[code] = &memEntry{
+		value:  j,
+		expiry: time.Now().Add(ttl),
+	}
+	ms.mx.Unlock()
+
+	return nil
+}
+
+// Consume populates "value" with the data previously saved
+// for the given code, and deletes the code from the store,
+// so that the code can be consumed exactly once.
+func (ms *MemStore) Consume(code string, value interface{}) error {
+	ms.mx.Lock()
+	entry, found := ms.entries[code]
+	delete(ms.entries, code)
+	ms.mx.Unlock()
+
+	if !found || time.Now().After(entry.expiry) {
+		return ErrCodeNotFound
+	}
+
+	err := json.Unmarshal(entry.value, value)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON to struct: %v", err)
+	}
+
+	return nil
+}
+
+// sweep periodically evicts expired entries from the store.
+func (ms *MemStore) sweep(sweepInterval time.Duration) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		ms.mx.Lock()
+		for code, entry := range ms.entries {
+			if now.After(entry.expiry) {
+				delete(ms.entries, code)
+			}
+		}
+		ms.mx.Unlock()
+	}
+}
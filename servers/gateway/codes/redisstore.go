@@ -0,0 +1,82 @@
+package codes
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// consumeScript gets and deletes a key atomically,
+// so that two concurrent consumers can't both get the same code.
+var consumeScript = redis.NewScript(`
+local val = redis.call("GET", KEYS[1])
+if val then
+	redis.call("DEL", KEYS[1])
+end
+return val
+`)
+
+// RedisStore represents a codes.Store backed by redis.
+type RedisStore struct {
+	// Redis client used to talk to Redis server.
+	Client *redis.Client
+	// Prefix added to every code to keep
+	// different kinds of codes separate from each other.
+	Prefix string
+}
+
+// NewRedisStore constructs a new RedisStore.
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	if client == nil {
+		panic("Nil Redis client")
+	}
+
+	if len(prefix) == 0 {
+		panic("Prefix has length of zero")
+	}
+
+	return &RedisStore{
+		Client: client,
+		Prefix: prefix,
+	}
+}
+
+// Store implementation
+
+// Save saves the provided "value" and associated code to the store.
+// The code expires after "ttl" if it is not consumed.
+func (rs *RedisStore) Save(code string, value interface{}, ttl time.Duration) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error marshalling struct to JSON: %v", err)
+	}
+
+	err = rs.Client.Set(rs.Prefix+code, j, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("error saving code to Redis: %v", err)
+	}
+
+	return nil
+}
+
+// Consume populates "value" with the data previously saved
+// for the given code, and deletes the code from the store,
+// so that the code can be consumed exactly once.
+func (rs *RedisStore) Consume(code string, value interface{}) error {
+	val, err := consumeScript.Run(rs.Client, []string{rs.Prefix + code}).String()
+	if err == redis.Nil {
+		return ErrCodeNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error consuming code from Redis: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(val), value)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON to struct: %v", err)
+	}
+
+	return nil
+}
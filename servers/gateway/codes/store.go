@@ -0,0 +1,26 @@
+package codes
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCodeNotFound is returned from Store.Consume() when the requested
+// code was not found in the store, either because it never existed,
+// it has expired, or it has already been consumed.
+var ErrCodeNotFound = errors.New("no code was found in the code store")
+
+// Store represents a store for short-lived, single-use codes,
+// such as password reset codes.
+// This is an abstract interface that can be implemented
+// against several different types of data stores.
+type Store interface {
+	// Save saves the provided "value" and associated code to the store.
+	// The code expires after "ttl" if it is not consumed.
+	Save(code string, value interface{}, ttl time.Duration) error
+
+	// Consume populates "value" with the data previously saved
+	// for the given code, and deletes the code from the store,
+	// so that the code can be consumed exactly once.
+	Consume(code string, value interface{}) error
+}
@@ -8,6 +8,21 @@ export TLS_CERT="$(pwd)/tls/fullchain.pem"
 export TLS_KEY="$(pwd)/tls/privkey.pem"
 export SESSION_KEY=seeitrun
 
+# The key microservices sign their announcements with is a deployment secret,
+# so it is never kept in the repository. It must be shared with
+# every microservice and differ from SESSION_KEY.
+if [ -z "$SERVICE_KEY" ]; then
+    echo 'Please set SERVICE_KEY environment variable'
+    exit 1
+fi
+if [ "$SERVICE_KEY" = "$SESSION_KEY" ]; then
+    echo 'SERVICE_KEY must be different from SESSION_KEY'
+    exit 1
+fi
+
+# Log outgoing mail, including reset codes, to the terminal.
+export MAIL_LOG=stdout
+
 export REDIS_ADDR=localhost:6379
 export MONGO_ADDR=localhost:27017
 export MQ_ADDR=localhost:5672
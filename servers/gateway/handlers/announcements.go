@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidSignature is returned from AnnouncementVerifier.Verify
+// when an announcement wasn't signed with the service key.
+var ErrInvalidSignature = errors.New("invalid announcement signature")
+
+// SignedAnnouncement represents a message received from the Redis Pub/Sub
+// microservices channel. Payload is the ReceivedService encoded as JSON,
+// and Signature is the hex-encoded HMAC-SHA256 of "<Timestamp>.<Payload>"
+// computed with the service key shared by the gateway and its microservices.
+type SignedAnnouncement struct {
+	Payload   string `json:"payload"`
+	Timestamp int64  `json:"timestamp"` // Seconds since the Unix epoch.
+	Signature string `json:"signature"`
+}
+
+// AnnouncementVerifier verifies signed microservice announcements.
+type AnnouncementVerifier struct {
+	serviceKey []byte
+	// How far the timestamp of an announcement may be from now,
+	// so that old announcements can't be replayed.
+	maxSkew time.Duration
+}
+
+// NewAnnouncementVerifier constructs a new AnnouncementVerifier.
+func NewAnnouncementVerifier(serviceKey string, maxSkew time.Duration) *AnnouncementVerifier {
+	if len(serviceKey) == 0 {
+		panic("Service key has length of zero")
+	}
+
+	if maxSkew <= 0 {
+		panic("Max skew must be positive")
+	}
+
+	return &AnnouncementVerifier{[]byte(serviceKey), maxSkew}
+}
+
+// Verify checks the signature and timestamp of the announcement in "msg",
+// and returns the ReceivedService it carries.
+func (verifier *AnnouncementVerifier) Verify(msg []byte, now time.Time) (*ReceivedService, error) {
+	announcement := &SignedAnnouncement{}
+	err := json.Unmarshal(msg, announcement)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling announcement JSON to struct: %v", err)
+	}
+
+	signature, err := hex.DecodeString(announcement.Signature)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if !hmac.Equal(signature, verifier.sign(announcement.Timestamp, announcement.Payload)) {
+		return nil, ErrInvalidSignature
+	}
+
+	// Only check the timestamp once it is known to be signed.
+	skew := now.Sub(time.Unix(announcement.Timestamp, 0))
+	if skew > verifier.maxSkew || skew < -verifier.maxSkew {
+		return nil, fmt.Errorf("announcement timestamp is %v away from now, more than %v allowed", skew, verifier.maxSkew)
+	}
+
+	receivedSvc := &ReceivedService{}
+	err = json.Unmarshal([]byte(announcement.Payload), receivedSvc)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling received microservice JSON to struct: %v", err)
+	}
+	return receivedSvc, nil
+}
+
+// sign returns the HMAC-SHA256 of the timestamp and payload.
+func (verifier *AnnouncementVerifier) sign(timestamp int64, payload string) []byte {
+	mac := hmac.New(sha256.New, verifier.serviceKey)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
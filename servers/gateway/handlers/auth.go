@@ -3,9 +3,13 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
 	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
 	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -25,34 +29,43 @@ func (ctx *HandlerContext) AdminsHandler(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Validate the NewAdmin.
-		err = newAdmin.Validate(ctx.signingKey)
+		err = newAdmin.Validate()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Ensure there isn't already a admin in the admin store with the same email address.
-		_, err = ctx.adminStore.GetByEmail(newAdmin.Email)
-		if err == nil {
-			http.Error(w, "Admin with the same email already exists", http.StatusBadRequest)
+		// Consume the invitation, so that it can be used only once.
+		invitation, err := consumeInvitation(ctx, newAdmin.InvitationCode, newAdmin.Email)
+		if err == codes.ErrCodeNotFound {
+			http.Error(w, "Invitation code is not valid", http.StatusForbidden)
 			return
 		}
-
-		// Ensure there isn't already a admin in the admin store with the same admin name.
-		_, err = ctx.adminStore.GetByUserName(newAdmin.UserName)
-		if err == nil {
-			http.Error(w, "Admin with the same username already exists", http.StatusBadRequest)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error consuming invitation: %v", err), http.StatusInternalServerError)
 			return
 		}
 
+		// The new admin gets the role granted by the invitation.
+		newAdmin.Role = invitation.Role
+
 		// Insert the new admin into the admin store.
+		// The store guarantees that there isn't already an admin
+		// with the same email address or username.
 		admin, err := ctx.adminStore.Insert(newAdmin)
+		if err != nil {
+			restoreInvitation(ctx, newAdmin.InvitationCode, invitation)
+		}
+		if err == admins.ErrDuplicateEmail || err == admins.ErrDuplicateUserName {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Error inserting new admin: %v", err), http.StatusInternalServerError)
 			return
 		}
 
-		beginNewSession(ctx, admin, w)
+		beginNewSession(ctx, admin, w, r)
 
 	default:
 		http.Error(w, "Expect POST method only", http.StatusMethodNotAllowed)
@@ -64,7 +77,7 @@ func (ctx *HandlerContext) AdminsHandler(w http.ResponseWriter, r *http.Request)
 func (ctx *HandlerContext) AdminsMeHandler(w http.ResponseWriter, r *http.Request) {
 	// Get session state from session store.
 	sessionState := &SessionState{}
-	sessionID, err := sessions.GetState(r, ctx.signingKey, ctx.sessionStore, sessionState)
+	sessionID, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
 		return
@@ -117,21 +130,188 @@ func (ctx *HandlerContext) AdminsMeHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-	// If clients send requests that are neither GET nor PATCH...
+	// Delete the current admin after re-confirming their password,
+	// and end all of their sessions.
+	case "DELETE":
+		passwordConfirmation := &admins.PasswordConfirmation{}
+		err := json.NewDecoder(r.Body).Decode(passwordConfirmation)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// The password hash is not kept in the session state,
+		// so get the admin from the admin store.
+		admin, err := ctx.adminStore.GetByID(sessionState.Admin.ID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting admin from admin store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		err = authenticate(ctx, admin, passwordConfirmation.Password)
+		if err != nil {
+			http.Error(w, invalidCredentials, http.StatusUnauthorized)
+			return
+		}
+
+		err = deleteAdmin(ctx, admin.ID)
+		if err == admins.ErrLastOwner {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error deleting admin: %v", err), http.StatusInternalServerError)
+			return
+		}
+		ctx.transport.ClearToken(w)
+
+		w.Write([]byte("Admin deleted"))
+
+	// If clients send requests that are neither GET, PATCH, nor DELETE...
 	default:
-		http.Error(w, "Expect GET or PATCH method only", http.StatusMethodNotAllowed)
+		http.Error(w, "Expect GET, PATCH, or DELETE method only", http.StatusMethodNotAllowed)
 		return
 	}
 }
 
+// SpecificAdminHandler handles requests for a specific admin resource,
+// and allows owners to delete other admins.
+// The resource path is /v1/admins/{id}.
+func (ctx *HandlerContext) SpecificAdminHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be DELETE.
+	if r.Method != "DELETE" {
+		http.Error(w, "Expect DELETE method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	_, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// Only owners can delete other admins.
+	if sessionState.Admin.Role != admins.RoleOwner {
+		http.Error(w, "Only owners can delete admins", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/admins/")
+	if !bson.IsObjectIdHex(id) {
+		http.Error(w, "Invalid admin ID", http.StatusBadRequest)
+		return
+	}
+
+	// Deleting oneself requires re-confirming the password,
+	// which only the "current admin" resource does.
+	if bson.ObjectIdHex(id) == sessionState.Admin.ID {
+		http.Error(w, "Use /v1/admins/me to delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	err = deleteAdmin(ctx, bson.ObjectIdHex(id))
+	if err == admins.ErrAdminNotFound {
+		http.Error(w, "Admin not found", http.StatusNotFound)
+		return
+	}
+	if err == admins.ErrLastOwner {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error deleting admin: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Admin deleted"))
+}
+
+// AdminsMePasswordHandler handles requests for the "current admin password" resource,
+// and allows the current admin to change their password.
+func (ctx *HandlerContext) AdminsMePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be PUT.
+	if r.Method != "PUT" {
+		http.Error(w, "Expect PUT method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	sessionID, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// Decode the request body into a admins.PasswordChange struct.
+	passwordChange := &admins.PasswordChange{}
+	err = json.NewDecoder(r.Body).Decode(passwordChange)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Validate the new password with the same rules used for signing up.
+	err = passwordChange.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The password hash is not kept in the session state,
+	// so get the admin from the admin store.
+	admin, err := ctx.adminStore.GetByID(sessionState.Admin.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting admin from admin store: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Authenticate the admin using the current password.
+	err = admin.Authenticate(passwordChange.CurrentPassword)
+	if err != nil {
+		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	err = admin.SetPassword(passwordChange.NewPassword)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error setting password: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = ctx.adminStore.SetPassHash(admin.ID, admin.PassHash)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Sign out everywhere else, so that anyone
+	// who knew the old password loses access.
+	err = endAdminSessions(ctx, admin.ID, sessionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error ending other sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Password changed"))
+}
+
 var invalidCredentials = "Invalid credentials"
 
 // SessionsHandler handles requests for the "sessions" resource,
-// and allows clients to begin a new session using an existing admin's credentials.
+// and allows clients to begin a new session using an existing admin's credentials,
+// or to list the current admin's sessions.
 func (ctx *HandlerContext) SessionsHandler(w http.ResponseWriter, r *http.Request) {
-	// Method must be POST.
+	if r.Method == "GET" {
+		listSessions(ctx, w, r)
+		return
+	}
+
+	// Otherwise, method must be POST.
 	if r.Method != "POST" {
-		http.Error(w, "Expect POST method only", http.StatusMethodNotAllowed)
+		http.Error(w, "Expect GET or POST method only", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -143,25 +323,62 @@ func (ctx *HandlerContext) SessionsHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Count the attempt before looking at the credentials, so that
+	// parallel guesses can't all get in before the first one fails,
+	// and refuse to even look at them while either the email
+	// or the client IP is locked out.
+	emailKey, ipKey := loginKeys(credentials.Email, r)
+	retryAfter, err := ctx.loginLimiter.Attempt(emailKey, ipKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error counting attempt: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		tooManyRequests(w, retryAfter)
+		return
+	}
+
 	// Get the admin with the provided email from the adminStore.
 	// If not found, respond with an http.StatusUnauthorized error
 	// and the message "Invalid credentials".
 	admin, err := ctx.adminStore.GetByEmail(credentials.Email)
+	if err == admins.ErrAdminNotFound {
+		loginFailed(ctx, w, emailKey, ipKey)
+		return
+	}
 	if err != nil {
-		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		http.Error(w, fmt.Sprintf("Error getting admin from admin store: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Authenticate the admin using the provided password.
 	// If that fails, respond with an http.StatusUnauthorized error
 	// and the message "invalid credentials".
-	err = admin.Authenticate(credentials.Password)
+	err = authenticate(ctx, admin, credentials.Password)
 	if err != nil {
-		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		loginFailed(ctx, w, emailKey, ipKey)
+		return
+	}
+
+	// Admins with two-factor authentication only get a pending session,
+	// and attempts against the email are kept until the second factor is provided.
+	if admin.TOTPEnabled {
+		err = ctx.loginLimiter.Forgive(ipKey)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error forgiving attempt: %v", err), http.StatusInternalServerError)
+			return
+		}
+		beginPendingSession(ctx, admin, w)
 		return
 	}
 
-	beginNewSession(ctx, admin, w)
+	err = loginSucceeded(ctx, emailKey, ipKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resetting failed attempts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	beginNewSession(ctx, admin, w, r)
 }
 
 // SessionsMineHandler handles requests for the "current session" resource,
@@ -175,36 +392,73 @@ func (ctx *HandlerContext) SessionsMineHandler(w http.ResponseWriter, r *http.Re
 
 	// Get session state from session store.
 	sessionState := &SessionState{}
-	_, err := sessions.GetState(r, ctx.signingKey, ctx.sessionStore, sessionState)
+	_, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
 		return
 	}
 
 	// End the current session.
-	_, err = sessions.EndSession(r, ctx.signingKey, ctx.sessionStore)
+	_, err = sessions.EndSession(r, ctx.keyring, ctx.transport, ctx.sessionStore)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error ending session: %v", err), http.StatusInternalServerError)
 		return
 	}
+	ctx.transport.ClearToken(w)
 
 	w.Write([]byte("Signed out"))
 }
 
+// authenticate authenticates the admin using the password,
+// and upgrades the admin's password hash if it was made
+// with a bcrypt cost other than the configured one.
+func authenticate(ctx *HandlerContext, admin *admins.Admin, password string) error {
+	err := admin.Authenticate(password)
+	if err != nil {
+		return err
+	}
+
+	if !admin.NeedsRehash() {
+		return nil
+	}
+
+	// The admin is authenticated either way,
+	// so failing to upgrade the hash is only logged.
+	err = admin.SetPassword(password)
+	if err == nil {
+		err = ctx.adminStore.SetPassHash(admin.ID, admin.PassHash)
+	}
+	if err != nil {
+		log.Printf("Error upgrading password hash of admin %s: %v\n", admin.ID.Hex(), err)
+	}
+	return nil
+}
+
 // begineNewSession begins a new session
 // and respond to the client with the Admin encoded as a JSON object.
-func beginNewSession(ctx *HandlerContext, admin *admins.Admin, w http.ResponseWriter) {
+func beginNewSession(ctx *HandlerContext, admin *admins.Admin, w http.ResponseWriter, r *http.Request) {
 	sessionState := SessionState{
 		BeginTime: time.Now(),
 		Admin:     admin,
+		ClientIP:  getClientIP(r),
+		UserAgent: r.UserAgent(),
 	}
 
-	_, err := sessions.BeginSession(ctx.signingKey, ctx.sessionStore, sessionState, w)
+	sessionID, err := sessions.BeginSession(ctx.keyring, ctx.transport, ctx.sessionStore, sessionState, w)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error beginning session: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// Index the new session by admin ID,
+	// so that it can be ended along with the admin's other sessions.
+	err = ctx.sessionStore.Index(admin.ID.Hex(), sessionID)
+	if err != nil {
+		ctx.sessionStore.Delete(sessionID)
+		http.Error(w, fmt.Sprintf("Error indexing session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Add(headerContentType, contentTypeJSON)
 	w.WriteHeader(http.StatusCreated)
 
@@ -214,3 +468,32 @@ func beginNewSession(ctx *HandlerContext, admin *admins.Admin, w http.ResponseWr
 		return
 	}
 }
+
+// endAdminSessions ends every session belonging to the given admin,
+// except the one identified by "keep".
+// Pass sessions.InvalidSessionToken as "keep" to end all of them.
+func endAdminSessions(ctx *HandlerContext, adminID bson.ObjectId, keep sessions.SessionToken) error {
+	sessionTokens, err := ctx.sessionStore.Tokens(adminID.Hex())
+	if err != nil {
+		return err
+	}
+	sessionTokensToEnd := []sessions.SessionToken{}
+	for _, sessionToken := range sessionTokens {
+		if sessionToken != keep {
+			sessionTokensToEnd = append(sessionTokensToEnd, sessionToken)
+		}
+	}
+	return ctx.sessionStore.DeleteMany(adminID.Hex(), sessionTokensToEnd...)
+}
+
+// deleteAdmin deletes the admin with the given ID from the admin store,
+// and ends all of that admin's sessions.
+// The admin store never deletes the last owner,
+// and returns admins.ErrLastOwner instead.
+func deleteAdmin(ctx *HandlerContext, adminID bson.ObjectId) error {
+	err := ctx.adminStore.Delete(adminID)
+	if err != nil {
+		return err
+	}
+	return endAdminSessions(ctx, adminID, sessions.InvalidSessionToken)
+}
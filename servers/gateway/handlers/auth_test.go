@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
+	"github.com/zicodeng/visitorex/servers/gateway/mailer"
+	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/ratelimit"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery staple"
+
+// newTestContext returns a HandlerContext backed by in-memory stores,
+// along with an owner signed up with testPassword.
+func newTestContext(t *testing.T, loginLimiter ratelimit.Limiter) (*HandlerContext, *admins.Admin) {
+	if err := admins.SetBcryptCost(bcrypt.MinCost); err != nil {
+		t.Fatalf("error setting bcrypt cost: %v", err)
+	}
+	keyring, err := sessions.NewKeyring("test")
+	if err != nil {
+		t.Fatalf("error creating keyring: %v", err)
+	}
+	adminStore := admins.NewMemStore()
+	admin, err := adminStore.Insert(&admins.NewAdmin{
+		Email:        "owner@example.com",
+		Password:     testPassword,
+		PasswordConf: testPassword,
+		UserName:     "owner",
+		FirstName:    "Test",
+		LastName:     "Owner",
+		Role:         admins.RoleOwner,
+	})
+	if err != nil {
+		t.Fatalf("error inserting admin: %v", err)
+	}
+	ctx := NewHandlerContext(
+		keyring,
+		&sessions.HeaderTransport{},
+		sessions.NewMemStore(time.Hour, time.Hour*24, time.Minute),
+		adminStore,
+		codes.NewMemStore(time.Minute),
+		codes.NewMemStore(time.Minute),
+		codes.NewMemStore(time.Minute),
+		codes.NewMemStore(time.Minute),
+		loginLimiter,
+		mailer.NewLogMailer(&bytes.Buffer{}),
+	)
+	return ctx, admin
+}
+
+// signIn posts the credentials to SessionsHandler and returns the status code.
+func signIn(t *testing.T, ctx *HandlerContext, email string, password string) int {
+	body, err := json.Marshal(&admins.Credentials{Email: email, Password: password})
+	if err != nil {
+		t.Fatalf("error marshaling credentials: %v", err)
+	}
+	r := httptest.NewRequest("POST", "/v1/sessions", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	ctx.SessionsHandler(w, r)
+	return w.Code
+}
+
+func TestSessionsHandlerLockoutIsTemporary(t *testing.T) {
+	const baseDelay = time.Millisecond * 50
+	limiter := ratelimit.NewMemLimiter(ratelimit.Policies{
+		EmailKeyPrefix: {Threshold: 5, BaseDelay: baseDelay, MaxDelay: time.Hour, Window: time.Hour},
+		IPKeyPrefix:    {Threshold: 100, BaseDelay: baseDelay, MaxDelay: time.Hour, Window: time.Hour},
+	}, time.Minute)
+	ctx, admin := newTestContext(t, limiter)
+
+	for i := 0; i < 5; i++ {
+		if code := signIn(t, ctx, admin.Email, "wrong password"); code != http.StatusUnauthorized {
+			t.Fatalf("expected %d for failed attempt %d but got %d", http.StatusUnauthorized, i+1, code)
+		}
+	}
+
+	// Even the correct password is refused during the lockout.
+	if code := signIn(t, ctx, admin.Email, testPassword); code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d during lockout but got %d", http.StatusTooManyRequests, code)
+	}
+
+	// Once the lockout is over, the password is checked again.
+	time.Sleep(baseDelay + time.Millisecond*20)
+	if code := signIn(t, ctx, admin.Email, "wrong password"); code != http.StatusUnauthorized {
+		t.Fatalf("expected %d for failed attempt after lockout but got %d", http.StatusUnauthorized, code)
+	}
+	if code := signIn(t, ctx, admin.Email, testPassword); code != http.StatusCreated {
+		t.Fatalf("expected %d for correct password after lockout but got %d", http.StatusCreated, code)
+	}
+
+	// Signing in clears the failed attempts of the email.
+	lockouts, err := limiter.Lockouts()
+	if err != nil {
+		t.Fatalf("error getting lockouts: %v", err)
+	}
+	if len(lockouts) != 0 {
+		t.Errorf("expected no lockouts after signing in but got %d", len(lockouts))
+	}
+}
+
+func TestSessionsHandlerLockoutDoubles(t *testing.T) {
+	const baseDelay = time.Millisecond * 50
+	limiter := ratelimit.NewMemLimiter(ratelimit.Policies{
+		EmailKeyPrefix: {Threshold: 2, BaseDelay: baseDelay, MaxDelay: time.Hour, Window: time.Hour},
+		IPKeyPrefix:    {Threshold: 100, BaseDelay: baseDelay, MaxDelay: time.Hour, Window: time.Hour},
+	}, time.Minute)
+	ctx, admin := newTestContext(t, limiter)
+
+	for lockout := 1; lockout <= 2; lockout++ {
+		for i := 0; i < 2; i++ {
+			if code := signIn(t, ctx, admin.Email, "wrong password"); code != http.StatusUnauthorized {
+				t.Fatalf("expected %d for failed attempt but got %d", http.StatusUnauthorized, code)
+			}
+		}
+		lockouts, err := limiter.Lockouts()
+		if err != nil {
+			t.Fatalf("error getting lockouts: %v", err)
+		}
+		if len(lockouts) != 1 {
+			t.Fatalf("expected 1 lockout but got %d", len(lockouts))
+		}
+		expected := baseDelay * time.Duration(lockout)
+		if remaining := time.Until(lockouts[0].ExpiresAt); remaining <= expected/2 || remaining > expected {
+			t.Errorf("expected lockout %d to last about %v but %v remain", lockout, expected, remaining)
+		}
+		time.Sleep(expected + time.Millisecond*20)
+	}
+
+	if code := signIn(t, ctx, admin.Email, testPassword); code != http.StatusCreated {
+		t.Fatalf("expected %d for correct password after lockouts but got %d", http.StatusCreated, code)
+	}
+}
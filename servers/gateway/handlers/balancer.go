@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+)
+
+// Names of the load-balancing strategies
+// microservices can choose from when they announce themselves.
+const (
+	BalancerRoundRobin       = "round-robin"
+	BalancerLeastOutstanding = "least-outstanding"
+	BalancerWeighted         = "weighted"
+	BalancerConsistentHash   = "consistent-hash"
+)
+
+// Balancer picks the instance of a microservice a request is forwarded to.
+// Its methods are called with the lock of the microservice held,
+// so implementations don't need to synchronize themselves.
+type Balancer interface {
+	// Pick returns one of the available instances, which are sorted by address
+	// and never empty, to forward the request "r" to.
+	Pick(instances []*serviceInstance, r *http.Request) *serviceInstance
+}
+
+// balancerStrategy returns the name of the strategy "name" stands for,
+// so that the default strategy has a single name.
+func balancerStrategy(name string) string {
+	if len(name) == 0 {
+		return BalancerRoundRobin
+	}
+	return name
+}
+
+// newBalancer returns a new Balancer implementing the strategy with the given name,
+// or nil if there is no such strategy.
+// The default strategy is round robin.
+func newBalancer(name string) Balancer {
+	switch name {
+	case "", BalancerRoundRobin:
+		return &roundRobinBalancer{}
+	case BalancerLeastOutstanding:
+		return &leastOutstandingBalancer{}
+	case BalancerWeighted:
+		return &weightedBalancer{currentWeights: make(map[string]int)}
+	case BalancerConsistentHash:
+		return &consistentHashBalancer{fallback: &roundRobinBalancer{}}
+	default:
+		return nil
+	}
+}
+
+// roundRobinBalancer sends requests to each instance in turn.
+type roundRobinBalancer struct {
+	next int
+}
+
+// Pick implements the Balancer interface.
+func (b *roundRobinBalancer) Pick(instances []*serviceInstance, r *http.Request) *serviceInstance {
+	instance := instances[b.next%len(instances)]
+	b.next++
+	return instance
+}
+
+// leastOutstandingBalancer sends requests to the instance
+// with the fewest requests in flight.
+type leastOutstandingBalancer struct{}
+
+// Pick implements the Balancer interface.
+func (b *leastOutstandingBalancer) Pick(instances []*serviceInstance, r *http.Request) *serviceInstance {
+	least := instances[0]
+	for _, instance := range instances[1:] {
+		if instance.outstanding < least.outstanding {
+			least = instance
+		}
+	}
+	return least
+}
+
+// weightedBalancer sends requests to instances in proportion to their weights,
+// using the smooth weighted round-robin algorithm of nginx,
+// so that heavier instances don't get their requests in bursts.
+type weightedBalancer struct {
+	// The key of the currentWeights map is the address of the instance.
+	currentWeights map[string]int
+}
+
+// Pick implements the Balancer interface.
+func (b *weightedBalancer) Pick(instances []*serviceInstance, r *http.Request) *serviceInstance {
+	var best *serviceInstance
+	total := 0
+	for _, instance := range instances {
+		total += instance.weight
+		b.currentWeights[instance.address] += instance.weight
+		if best == nil || b.currentWeights[instance.address] > b.currentWeights[best.address] {
+			best = instance
+		}
+	}
+	b.currentWeights[best.address] -= total
+
+	// Forget instances that are gone.
+	if len(b.currentWeights) > len(instances) {
+		present := make(map[string]bool, len(instances))
+		for _, instance := range instances {
+			present[instance.address] = true
+		}
+		for addr := range b.currentWeights {
+			if !present[addr] {
+				delete(b.currentWeights, addr)
+			}
+		}
+	}
+
+	return best
+}
+
+// consistentHashBalancer sends all requests of an admin to the same instance,
+// so that per-admin caches on that instance stay warm.
+// It uses rendezvous hashing, so that adding or removing an instance
+// only moves the admins of that instance.
+// Requests without a signed-in admin are balanced by "fallback".
+type consistentHashBalancer struct {
+	fallback Balancer
+}
+
+// Pick implements the Balancer interface.
+func (b *consistentHashBalancer) Pick(instances []*serviceInstance, r *http.Request) *serviceInstance {
+	adminID := getAdminID(r)
+	if len(adminID) == 0 {
+		return b.fallback.Pick(instances, r)
+	}
+
+	var best *serviceInstance
+	var bestScore uint64
+	for _, instance := range instances {
+		h := fnv.New64a()
+		h.Write([]byte(instance.address))
+		h.Write([]byte{0})
+		h.Write([]byte(adminID))
+		score := h.Sum64()
+		if best == nil || score > bestScore {
+			best = instance
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// getAdminID returns the ID of the admin in the X-User header
+// added by DSDHandler, or an empty string if there is none.
+func getAdminID(r *http.Request) string {
+	userJSON := r.Header.Get(headerUser)
+	if len(userJSON) == 0 {
+		return ""
+	}
+	user := &struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.Unmarshal([]byte(userJSON), user); err != nil {
+		return ""
+	}
+	return user.ID
+}
@@ -5,6 +5,11 @@ const headerAccessControlAllowHeaders = "Access-Control-Allow-Headers"
 const headerAccessControlExposeHeaders = "Access-Control-Expose-Headers"
 const headerAccessControlAllowMethods = "Access-Control-Allow-Methods"
 const headerAccessControlMaxAge = "Access-Control-Max-Age"
+const headerAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+const headerVary = "Vary"
 
 const headerContentType = "Content-Type"
 const contentTypeJSON = "application/json"
+
+const headerRetryAfter = "Retry-After"
+const headerUser = "X-User"
@@ -1,26 +1,55 @@
 package handlers
 
 import (
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
+	"github.com/zicodeng/visitorex/servers/gateway/mailer"
 	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/ratelimit"
 	"github.com/zicodeng/visitorex/servers/gateway/sessions"
 )
 
 // HandlerContext will be a receiver on any of our HTTP
 // handler functions that need access to globals.
 type HandlerContext struct {
-	signingKey string
+	// Signs and validates session tokens,
+	// as well as other codes handed out to clients.
+	keyring *sessions.Keyring
+	// Carries session tokens between the gateway and clients.
+	transport sessions.Transport
 	// The type is an Store interface
 	// rather than an actual Store implementation.
-	sessionStore sessions.Store
-	adminStore   admins.Store
+	sessionStore    sessions.Store
+	adminStore      admins.Store
+	resetCodeStore  codes.Store
+	invitationStore codes.Store
+	ticketStore     codes.Store
+	// Sign-ins waiting for a second factor.
+	pendingLoginStore codes.Store
+	// Tracks failed sign-in attempts per email and per client IP.
+	loginLimiter ratelimit.Limiter
+	mailer       mailer.Mailer
 }
 
 // NewHandlerContext constructs a new HanderContext,
 // ensuring that the dependencies are valid values.
-func NewHandlerContext(signingKey string, sessionStore sessions.Store, adminStore admins.Store) *HandlerContext {
+func NewHandlerContext(
+	keyring *sessions.Keyring,
+	transport sessions.Transport,
+	sessionStore sessions.Store,
+	adminStore admins.Store,
+	resetCodeStore codes.Store,
+	invitationStore codes.Store,
+	ticketStore codes.Store,
+	pendingLoginStore codes.Store,
+	loginLimiter ratelimit.Limiter,
+	mailer mailer.Mailer) *HandlerContext {
 
-	if len(signingKey) == 0 {
-		panic("Signing key has length of zero")
+	if keyring == nil {
+		panic("Nil keyring")
+	}
+
+	if transport == nil {
+		panic("Nil transport")
 	}
 
 	if sessionStore == nil {
@@ -31,5 +60,29 @@ func NewHandlerContext(signingKey string, sessionStore sessions.Store, adminStor
 		panic("Nil admin store")
 	}
 
-	return &HandlerContext{signingKey, sessionStore, adminStore}
+	if resetCodeStore == nil {
+		panic("Nil reset code store")
+	}
+
+	if invitationStore == nil {
+		panic("Nil invitation store")
+	}
+
+	if ticketStore == nil {
+		panic("Nil ticket store")
+	}
+
+	if pendingLoginStore == nil {
+		panic("Nil pending login store")
+	}
+
+	if loginLimiter == nil {
+		panic("Nil login limiter")
+	}
+
+	if mailer == nil {
+		panic("Nil mailer")
+	}
+
+	return &HandlerContext{keyring, transport, sessionStore, adminStore, resetCodeStore, invitationStore, ticketStore, pendingLoginStore, loginLimiter, mailer}
 }
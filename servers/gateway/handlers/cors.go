@@ -8,21 +8,33 @@ import (
 // to do some pre- and/or post-processing of the request.
 type CORSHandler struct {
 	Handler http.Handler
+	// The only origin allowed to make credentialed requests,
+	// which is needed for cookie-based sessions.
+	// If empty, any origin is allowed without credentials.
+	AllowedOrigin string
 }
 
 // NewCORSHandler wraps another handler into CORSHandler.
-func NewCORSHandler(handlerToWrap http.Handler) *CORSHandler {
-	return &CORSHandler{handlerToWrap}
+func NewCORSHandler(handlerToWrap http.Handler, allowedOrigin string) *CORSHandler {
+	return &CORSHandler{handlerToWrap, allowedOrigin}
 }
 
 // ServeHTTP is a method of CORSHandler.
 // Now our CORSHandler is a http.Handler.
 func (ch *CORSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	w.Header().Add(headerAccessControlAllowOrigin, "*")
+	// Browsers refuse to send cookies to "*",
+	// so credentialed requests need an explicit origin.
+	if len(ch.AllowedOrigin) == 0 {
+		w.Header().Add(headerAccessControlAllowOrigin, "*")
+	} else {
+		w.Header().Add(headerAccessControlAllowOrigin, ch.AllowedOrigin)
+		w.Header().Add(headerAccessControlAllowCredentials, "true")
+		w.Header().Add(headerVary, "Origin")
+	}
 	w.Header().Add(headerAccessControlAllowMethods, "GET, PUT, POST, PATCH, DELETE")
-	w.Header().Add(headerAccessControlAllowHeaders, "Content-Type, Authorization")
-	w.Header().Add(headerAccessControlExposeHeaders, "Authorization")
+	w.Header().Add(headerAccessControlAllowHeaders, "Content-Type, Authorization, X-CSRF-Token")
+	w.Header().Add(headerAccessControlExposeHeaders, "Authorization, Retry-After, X-CSRF-Token")
 	w.Header().Add(headerAccessControlMaxAge, "600")
 
 	// If this is preflight request, the method will
@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
 	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"regexp"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,25 +20,77 @@ import (
 // ServiceList contains a list of services.
 type ServiceList struct {
 	services map[string]*service
-	mx       sync.RWMutex
+	// Services in the order requests are matched against them.
+	routes []*service
+	// Paths served by the gateway itself,
+	// which microservices are not allowed to take over.
+	reservedPaths []string
+	// Number of announcements rejected so far.
+	rejected int
+	// The key of the quarantine map is the name of the rejected microservice.
+	quarantine map[string]*QuarantinedService
+	mx         sync.RWMutex
 }
 
 // NewServiceList creates a new ServiceList.
 func NewServiceList() *ServiceList {
 	return &ServiceList{
-		services: make(map[string]*service),
+		services:   make(map[string]*service),
+		quarantine: make(map[string]*QuarantinedService),
 	}
 }
 
+// Reserve reserves a path served by the gateway itself.
+// Microservices whose path patterns overlap it,
+// or the paths beneath it, are rejected from then on.
+func (serviceList *ServiceList) Reserve(path string) {
+	serviceList.mx.Lock()
+	defer serviceList.mx.Unlock()
+	serviceList.reservedPaths = append(serviceList.reservedPaths, path)
+}
+
+// maxQuarantined is the maximum number of microservices kept in quarantine.
+const maxQuarantined = 100
+
+// QuarantinedService represents a microservice whose announcements are rejected.
+// It stays in quarantine until it sends a valid announcement.
+type QuarantinedService struct {
+	Name         string    `json:"name"`
+	Address      string    `json:"address"`
+	Reason       string    `json:"reason"`
+	Rejections   int       `json:"rejections"`
+	LastRejected time.Time `json:"lastRejected"`
+}
+
+// maxInstanceFailures is the number of consecutive connection errors
+// after which a microservice instance is ejected.
+const maxInstanceFailures = 3
+
+// ejectDuration is how long an ejected microservice instance
+// gets no traffic before it is tried again.
+const ejectDuration = time.Second * 30
+
 // service represents any microservice our gateway
 // will be received from Redis "microservice" channel.
 type service struct {
 	name              string
 	pathPatternRegexp *regexp.Regexp
 	heartbeat         int // The microservice's normal heartbeat.
+	// Whether the pattern only matches at the beginning of the path,
+	// the literal string every matching path begins with,
+	// and the priority among services whose prefixes are equally long.
+	anchored      bool
+	literalPrefix string
+	priority      int
+	proxy         *httputil.ReverseProxy
 	// The key of the instances map is this instance's unique address.
 	instances map[string]*serviceInstance
-	proxy     *httputil.ReverseProxy
+	// Name of the load-balancing strategy, and its implementation.
+	balancerName string
+	balancer     Balancer
+	// Guards the instances and the balancer,
+	// so that proxying requests doesn't lock the whole ServiceList.
+	mx sync.Mutex
 }
 
 // newService creates a new microservice.
@@ -41,13 +98,23 @@ func newService(
 	name string,
 	pathPatternRegexp *regexp.Regexp,
 	heartbeat int,
-	instances map[string]*serviceInstance) *service {
-	addrs := []string{}
-	for addr := range instances {
-		addrs = append(addrs, addr)
+	priority int,
+	instances map[string]*serviceInstance,
+	balancerName string,
+	balancer Balancer) *service {
+	svc := &service{
+		name:              name,
+		pathPatternRegexp: pathPatternRegexp,
+		heartbeat:         heartbeat,
+		anchored:          isAnchored(pathPatternRegexp.String()),
+		literalPrefix:     literalPrefix(pathPatternRegexp.String()),
+		priority:          priority,
+		instances:         instances,
+		balancerName:      balancerName,
+		balancer:          balancer,
 	}
-	proxy := newServiceProxy(addrs)
-	return &service{name, pathPatternRegexp, heartbeat, instances, proxy}
+	svc.proxy = newServiceProxy(svc)
+	return svc
 }
 
 // serviceInstance is an instance of a given microservice.
@@ -55,11 +122,40 @@ func newService(
 type serviceInstance struct {
 	address       string
 	lastHeartbeat time.Time
+	// Relative share of traffic under the weighted strategy.
+	weight int
+	// Load-balancing strategy this instance last announced.
+	balancerName string
+	// Number of requests in flight.
+	outstanding int
+	// Number of consecutive connection errors.
+	failures int
+	// The instance gets no traffic until this time.
+	ejectedUntil time.Time
 }
 
 // newServiceInstance creates a new microservice instance.
-func newServiceInstance(addr string, lastHeartbeat time.Time) *serviceInstance {
-	return &serviceInstance{addr, lastHeartbeat}
+func newServiceInstance(addr string, weight int, balancerName string, lastHeartbeat time.Time) *serviceInstance {
+	return &serviceInstance{
+		address:       addr,
+		weight:        instanceWeight(weight),
+		balancerName:  balancerStrategy(balancerName),
+		lastHeartbeat: lastHeartbeat,
+	}
+}
+
+// instanceWeight returns the announced weight,
+// or 1 if the instance didn't announce a valid one.
+func instanceWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
+// available returns whether the instance may get traffic at "now".
+func (instance *serviceInstance) available(now time.Time) bool {
+	return !now.Before(instance.ejectedUntil)
 }
 
 // ReceivedService represents microservice information received from Redis Pub/Sub.
@@ -68,25 +164,180 @@ type ReceivedService struct {
 	PathPattern string
 	Address     string
 	Heartbeat   int
+	// Load-balancing strategy of the microservice,
+	// one of the Balancer* constants. Defaults to round robin.
+	Balancer string
+	// Relative share of traffic this instance gets
+	// under the weighted strategy. Defaults to 1.
+	Weight int
+	// Precedence over other microservices whose path patterns
+	// have equally long literal prefixes. Higher goes first.
+	Priority int
+}
+
+// Validate validates the received microservice and returns an error if
+// any of the validation rules fail, or nil if its valid.
+func (receivedSvc *ReceivedService) Validate() error {
+	// Name must be non-zero length.
+	if len(receivedSvc.Name) == 0 {
+		return fmt.Errorf("Name must be non-zero length")
+	}
+
+	// PathPattern must be a valid regular expression.
+	if len(receivedSvc.PathPattern) == 0 {
+		return fmt.Errorf("Path pattern must be non-zero length")
+	}
+	_, err := regexp.Compile(receivedSvc.PathPattern)
+	if err != nil {
+		return fmt.Errorf("Error compiling path pattern: %v", err)
+	}
+
+	// Address must be in the form of host:port.
+	host, port, err := net.SplitHostPort(receivedSvc.Address)
+	if err != nil {
+		return fmt.Errorf("Error parsing address: %v", err)
+	}
+	if len(host) == 0 {
+		return fmt.Errorf("Address must have a host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("Address must have a port between 1 and 65535")
+	}
+
+	// Heartbeat must be positive,
+	// or every instance would be removed as crashed right away.
+	if receivedSvc.Heartbeat <= 0 {
+		return fmt.Errorf("Heartbeat must be positive")
+	}
+
+	// Balancer must name a known strategy.
+	if newBalancer(receivedSvc.Balancer) == nil {
+		return fmt.Errorf("Unknown load balancing %q", receivedSvc.Balancer)
+	}
+
+	// Weight must not be negative.
+	if receivedSvc.Weight < 0 {
+		return fmt.Errorf("Weight must not be negative")
+	}
+
+	return nil
+}
+
+// ServiceQuarantine represents the rejected announcements
+// and the microservices in quarantine.
+type ServiceQuarantine struct {
+	Rejected   int                   `json:"rejected"`
+	Quarantine []*QuarantinedService `json:"quarantine"`
+}
+
+// Reject records that an announcement was rejected for the given reason,
+// and puts its microservice in quarantine.
+// "receivedSvc" is nil if the announcement couldn't even be decoded.
+func (serviceList *ServiceList) Reject(receivedSvc *ReceivedService, reason error) {
+	serviceList.mx.Lock()
+	defer serviceList.mx.Unlock()
+	serviceList.reject(receivedSvc, reason)
+}
+
+// reject implements Reject.
+// The caller must hold the lock of the service.
+func (serviceList *ServiceList) reject(receivedSvc *ReceivedService, reason error) {
+	serviceList.rejected++
+
+	name, addr := "", ""
+	if receivedSvc != nil {
+		name, addr = receivedSvc.Name, receivedSvc.Address
+	}
+	log.Printf("Microservice %q at address %q rejected: %v\n", name, addr, reason)
+
+	quarantined, found := serviceList.quarantine[name]
+	if !found {
+		// Anyone who can publish to the channel can make up names,
+		// so the quarantine list is bounded.
+		if len(serviceList.quarantine) >= maxQuarantined {
+			return
+		}
+		quarantined = &QuarantinedService{Name: name}
+		serviceList.quarantine[name] = quarantined
+	}
+	quarantined.Address = addr
+	quarantined.Reason = reason.Error()
+	quarantined.Rejections++
+	quarantined.LastRejected = time.Now()
+}
+
+// Rejected returns the number of announcements rejected so far,
+// and the microservices currently in quarantine.
+func (serviceList *ServiceList) Rejected() (int, []*QuarantinedService) {
+	serviceList.mx.RLock()
+	defer serviceList.mx.RUnlock()
+
+	quarantine := make([]*QuarantinedService, 0, len(serviceList.quarantine))
+	for _, quarantined := range serviceList.quarantine {
+		c := *quarantined
+		quarantine = append(quarantine, &c)
+	}
+	sort.Slice(quarantine, func(i, j int) bool {
+		return quarantine[i].Name < quarantine[j].Name
+	})
+	return serviceList.rejected, quarantine
 }
 
 // Register either registers a new microservice if it doesn't exist,
 // or register a new microservice instance if that microservice already exists in the list.
-func (serviceList *ServiceList) Register(receivedSvc *ReceivedService) {
+// Invalid announcements are rejected and returned as an error.
+func (serviceList *ServiceList) Register(receivedSvc *ReceivedService) error {
 	serviceList.mx.Lock()
+	defer serviceList.mx.Unlock()
+
+	err := receivedSvc.Validate()
+	if err == nil {
+		err = checkReservedPaths(receivedSvc.PathPattern, serviceList.reservedPaths)
+	}
+	if err != nil {
+		serviceList.reject(receivedSvc, err)
+		return err
+	}
+	if _, found := serviceList.quarantine[receivedSvc.Name]; found {
+		log.Printf("Microservice %s released from quarantine\n", receivedSvc.Name)
+		delete(serviceList.quarantine, receivedSvc.Name)
+	}
+
 	svc, hasSvc := serviceList.services[receivedSvc.Name]
 	// If this microservice is already in our list...
 	if hasSvc {
+		svc.mx.Lock()
 		// Check if this specific microservice instance exists in our list by its unique address...
+		balancerName := balancerStrategy(receivedSvc.Balancer)
 		instance, hasInstance := svc.instances[receivedSvc.Address]
+		// Only a new instance, or an instance announcing a different strategy
+		// than it did before, decides the strategy,
+		// so that instances disagreeing about it don't keep switching it
+		// on every heartbeat.
+		changedBalancer := !hasInstance || instance.balancerName != balancerName
 		if hasInstance {
 			// If this microservice instance is in our list,
 			// update its lastHeartbeat time field.
 			instance.lastHeartbeat = time.Now()
+			instance.weight = instanceWeight(receivedSvc.Weight)
+			instance.balancerName = balancerName
 		} else {
 			// If not, add this instance to our list.
 			log.Printf("Microservice %s: new instance with address %s found\n", receivedSvc.Name, receivedSvc.Address)
-			svc.instances[receivedSvc.Address] = newServiceInstance(receivedSvc.Address, time.Now())
+			svc.instances[receivedSvc.Address] = newServiceInstance(receivedSvc.Address, receivedSvc.Weight, receivedSvc.Balancer, time.Now())
+		}
+		// The balancer is only replaced when the strategy actually changes,
+		// so that it keeps its state across heartbeats.
+		if changedBalancer && balancerName != svc.balancerName {
+			log.Printf("Microservice %s: switched to %q load balancing\n", receivedSvc.Name, balancerName)
+			svc.balancerName = balancerName
+			svc.balancer = newBalancer(balancerName)
+		}
+		svc.mx.Unlock()
+		if receivedSvc.Priority != svc.priority {
+			log.Printf("Microservice %s: priority changed to %d\n", receivedSvc.Name, receivedSvc.Priority)
+			svc.priority = receivedSvc.Priority
+			serviceList.updateRoutes()
 		}
 	} else {
 		// If this microservice is not in our list,
@@ -95,15 +346,75 @@ func (serviceList *ServiceList) Register(receivedSvc *ReceivedService) {
 		log.Printf("New microservice %s found\n", receivedSvc.Name)
 		log.Printf("Microservice %s: new instance with address %s found\n", receivedSvc.Name, receivedSvc.Address)
 		instances := make(map[string]*serviceInstance)
-		instances[receivedSvc.Address] = newServiceInstance(receivedSvc.Address, time.Now())
-		serviceList.services[receivedSvc.Name] = newService(
+		instances[receivedSvc.Address] = newServiceInstance(receivedSvc.Address, receivedSvc.Weight, receivedSvc.Balancer, time.Now())
+		svc = newService(
 			receivedSvc.Name,
+			// The pattern has been validated already.
 			regexp.MustCompile(receivedSvc.PathPattern),
 			receivedSvc.Heartbeat,
+			receivedSvc.Priority,
 			instances,
+			balancerStrategy(receivedSvc.Balancer),
+			newBalancer(receivedSvc.Balancer),
 		)
+		serviceList.services[receivedSvc.Name] = svc
+		serviceList.updateRoutes()
+		serviceList.warnOverlaps(svc)
+	}
+	return nil
+}
+
+// QuarantineHandler is a handler for the "quarantined microservices" resource,
+// and allows admins to see which announcements are being rejected and why.
+type QuarantineHandler struct {
+	serviceList *ServiceList
+	ctx         *HandlerContext
+}
+
+// NewQuarantineHandler constructs a new QuarantineHandler.
+func (ctx *HandlerContext) NewQuarantineHandler(serviceList *ServiceList) *QuarantineHandler {
+	if serviceList == nil {
+		panic("nil service list")
+	}
+	return &QuarantineHandler{
+		serviceList: serviceList,
+		ctx:         ctx,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface for the QuarantineHandler.
+func (qh *QuarantineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Method must be GET.
+	if r.Method != "GET" {
+		http.Error(w, "Expect GET method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	_, err := sessions.GetState(r, qh.ctx.keyring, qh.ctx.transport, qh.ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// Only owners and managers can see quarantined microservices.
+	if !sessionState.Admin.Role.AtLeast(admins.RoleManager) {
+		http.Error(w, "Only owners and managers can see quarantined microservices", http.StatusForbidden)
+		return
+	}
+
+	rejected, quarantine := qh.serviceList.Rejected()
+
+	w.Header().Add(headerContentType, contentTypeJSON)
+	err = json.NewEncoder(w).Encode(&ServiceQuarantine{
+		Rejected:   rejected,
+		Quarantine: quarantine,
+	})
+	if err != nil {
+		http.Error(w, "Error encoding ServiceQuarantine struct to JSON", http.StatusInternalServerError)
+		return
 	}
-	serviceList.mx.Unlock()
 }
 
 // Remove either removes a dangling microservice if it does not have any active instance running,
@@ -117,6 +428,7 @@ func (serviceList *ServiceList) Remove() {
 	}
 
 	for svcName, svc := range serviceList.services {
+		svc.mx.Lock()
 		for addr, instance := range svc.instances {
 			if time.Now().Sub(instance.lastHeartbeat).Seconds() > float64(svc.heartbeat)+10 {
 				log.Printf("Microservice %s: crashed instance with address %s removed", svcName, addr)
@@ -127,50 +439,62 @@ func (serviceList *ServiceList) Remove() {
 				if len(svc.instances) == 0 {
 					log.Printf("Dangling microservice %s removed\n", svcName)
 					delete(serviceList.services, svcName)
+					serviceList.updateRoutes()
 				}
 			}
 		}
+		svc.mx.Unlock()
 	}
 }
 
 // DSDHandler is a dynamic service discovery middleware handler
 // that checks the requested resource path
 // against the pathPattern properties of the services field.
+// Paths served by the wrapped mux are never forwarded to microservices.
 type DSDHandler struct {
-	handler     http.Handler
+	mux         *http.ServeMux
 	serviceList *ServiceList
 	ctx         *HandlerContext
 }
 
-// NewDSDHandler wraps another handler into DSDHandler.
-func NewDSDHandler(handlerToWrap http.Handler, serviceList *ServiceList, ctx *HandlerContext) *DSDHandler {
-	return &DSDHandler{handlerToWrap, serviceList, ctx}
+// NewDSDHandler wraps the gateway's mux into DSDHandler.
+func NewDSDHandler(muxToWrap *http.ServeMux, serviceList *ServiceList, ctx *HandlerContext) *DSDHandler {
+	return &DSDHandler{muxToWrap, serviceList, ctx}
 }
 
 // ServeHTTP is a method of DSDHandler.
 // Now our DSDHandler is a http.Handler.
 func (dsdh *DSDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Always remove any X-User header sent by the client first,
+	// to prevent a hacker who tries to sneak in
+	// by setting a fake X-User header in the request.
+	r.Header.Del(headerUser)
+
 	// Validate the user.
 	user := dsdh.getCurrentUser(r)
 	if user != nil {
 		userJSON, err := json.Marshal(user)
 		if err != nil {
 			log.Printf("error marshaling user: %v", err)
+		} else {
+			r.Header.Set(headerUser, string(userJSON))
 		}
-		r.Header.Add("X-User", string(userJSON))
-	} else {
-		// If there is no user found,
-		// explicitly remove X-User header to
-		// prevent a hacker who tries to sneak in
-		// by setting a fake X-User header in the request.
-		r.Header.Del("X-User")
+	}
+
+	// Paths the gateway serves itself are never forwarded,
+	// even if a microservice path pattern slipped past the reserved paths.
+	if _, pattern := dsdh.mux.Handler(r); len(pattern) != 0 {
+		dsdh.mux.ServeHTTP(w, r)
+		return
 	}
 
 	// Use the received microservice path pattern
 	// to determine which microservice should this requset
 	// be forwarded to.
+	// The routing table is sorted, so overlapping patterns
+	// always route to the same microservice.
 	dsdh.serviceList.mx.RLock()
-	for _, svc := range dsdh.serviceList.services {
+	for _, svc := range dsdh.serviceList.routes {
 		pattern := svc.pathPatternRegexp
 		if pattern.MatchString(r.URL.Path) {
 			dsdh.serviceList.mx.RUnlock()
@@ -185,28 +509,136 @@ func (dsdh *DSDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// If no match is found,
 	// it means this request should not be forwarded to any microservices,
 	// just call our real handler to handle it.
-	dsdh.handler.ServeHTTP(w, r)
+	dsdh.mux.ServeHTTP(w, r)
 }
 
 // newServiceProxy forwards relevant requests to microservices based on resource path.
 // The microservices should have corresponding handlers that can handle those requests.
-func newServiceProxy(addrs []string) *httputil.ReverseProxy {
-	i := 0
-	mutex := sync.Mutex{}
+// Every request goes to the instance of the service chosen by its Balancer at that moment,
+// and instances that keep failing to connect are ejected for a while.
+// Only the lock of the service is taken, so that requests to different
+// microservices don't contend with each other.
+func newServiceProxy(svc *service) *httputil.ReverseProxy {
 	return &httputil.ReverseProxy{
 		Director: func(r *http.Request) {
-			mutex.Lock()
-			r.URL.Host = addrs[i%len(addrs)]
-			i++
-			mutex.Unlock()
+			svc.mx.Lock()
+			if instance := svc.pickInstance(r, time.Now()); instance != nil {
+				instance.outstanding++
+				r.URL.Host = instance.address
+			} else {
+				r.URL.Host = ""
+			}
+			svc.mx.Unlock()
 			r.URL.Scheme = "http"
 		},
+		ModifyResponse: func(res *http.Response) error {
+			addr := res.Request.URL.Host
+			svc.mx.Lock()
+			if instance, found := svc.instances[addr]; found {
+				instance.failures = 0
+			}
+			// The proxy needs the body of an upgraded connection
+			// to be writable, so it can't be wrapped.
+			// Upgraded connections are long-lived,
+			// so the request is counted as done right away.
+			if res.StatusCode == http.StatusSwitchingProtocols {
+				svc.requestDone(addr)
+				svc.mx.Unlock()
+				return nil
+			}
+			svc.mx.Unlock()
+			// The request is in flight until the whole body has been copied,
+			// which the proxy signals by closing it.
+			res.Body = &doneReadCloser{ReadCloser: res.Body, done: func() {
+				svc.mx.Lock()
+				svc.requestDone(addr)
+				svc.mx.Unlock()
+			}}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			svc.mx.Lock()
+			svc.requestDone(r.URL.Host)
+			// Clients going away is not the instance's fault.
+			if r.Context().Err() == nil {
+				svc.recordFailure(r.URL.Host, time.Now())
+			}
+			svc.mx.Unlock()
+			log.Printf("Microservice %s: error proxying to instance with address %s: %v\n", svc.name, r.URL.Host, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+// doneReadCloser calls "done" once when it is closed.
+type doneReadCloser struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+// Close implements the io.Closer interface.
+func (rc *doneReadCloser) Close() error {
+	rc.once.Do(rc.done)
+	return rc.ReadCloser.Close()
+}
+
+// pickInstance returns the instance the request should go to,
+// or nil if the service has no instances left.
+// If every instance is ejected, they are all considered anyway,
+// since that is still better than failing every request.
+// The caller must hold the lock of the service.
+func (svc *service) pickInstance(r *http.Request, now time.Time) *serviceInstance {
+	instances := []*serviceInstance{}
+	for _, instance := range svc.instances {
+		if instance.available(now) {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		for _, instance := range svc.instances {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+
+	// Map iteration order is random,
+	// so sort the instances to give balancers a stable order.
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].address < instances[j].address
+	})
+	return svc.balancer.Pick(instances, r)
+}
+
+// requestDone marks a request to the instance with the given address as finished.
+// The caller must hold the lock of the service.
+func (svc *service) requestDone(addr string) {
+	if instance, found := svc.instances[addr]; found && instance.outstanding > 0 {
+		instance.outstanding--
+	}
+}
+
+// recordFailure counts a connection error of the instance with the given address,
+// and ejects it once it has failed too many times in a row.
+// The caller must hold the lock of the service.
+func (svc *service) recordFailure(addr string, now time.Time) {
+	instance, found := svc.instances[addr]
+	if !found {
+		return
+	}
+	instance.failures++
+	if instance.failures >= maxInstanceFailures {
+		log.Printf("Microservice %s: failing instance with address %s ejected for %v\n", svc.name, addr, ejectDuration)
+		instance.failures = 0
+		instance.ejectedUntil = now.Add(ejectDuration)
 	}
 }
 
 func (dsdh *DSDHandler) getCurrentUser(r *http.Request) *admins.Admin {
 	sessionState := &SessionState{}
-	_, err := sessions.GetState(r, dsdh.ctx.signingKey, dsdh.ctx.sessionStore, sessionState)
+	_, err := sessions.GetState(r, dsdh.ctx.keyring, dsdh.ctx.transport, dsdh.ctx.sessionStore, sessionState)
 	if err != nil {
 		return nil
 	}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
+	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"time"
+)
+
+// invitationDuration is how long an invitation stays valid.
+const invitationDuration = time.Hour * 72
+
+// InvitationsHandler handles requests for the "invitations" resource,
+// and allows the current admin to invite a new admin by email.
+func (ctx *HandlerContext) InvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be POST.
+	if r.Method != "POST" {
+		http.Error(w, "Expect POST method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	_, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	newInvitation := &admins.NewInvitation{}
+	err = json.NewDecoder(r.Body).Decode(newInvitation)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = newInvitation.Validate(sessionState.Admin.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := ctx.SendInvitation(newInvitation, sessionState.Admin.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error sending invitation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add(headerContentType, contentTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(invitation)
+	if err != nil {
+		http.Error(w, "Error encoding Invitation struct to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SendInvitation issues an invitation code for the new invitation
+// and sends it to the invited email address.
+// The invitation code itself is only ever delivered by email.
+func (ctx *HandlerContext) SendInvitation(newInvitation *admins.NewInvitation, inviterID bson.ObjectId) (*admins.Invitation, error) {
+	invitation := newInvitation.ToInvitation(inviterID, invitationDuration)
+
+	invitationCode, err := issueCode(ctx, ctx.invitationStore, invitation, invitationDuration)
+	if err != nil {
+		return nil, fmt.Errorf("error issuing invitation code: %v", err)
+	}
+
+	body := fmt.Sprintf(
+		"You have been invited to sign up as a Visitorex admin.\n\nYour invitation code is:\n\n%s\n\nIt expires at %v.",
+		invitationCode,
+		invitation.ExpiresAt.Format(time.RFC1123),
+	)
+	err = ctx.mailer.Send(invitation.Email, "Visitorex invitation", body)
+	if err != nil {
+		return nil, fmt.Errorf("error sending invitation code: %v", err)
+	}
+
+	return invitation, nil
+}
+
+// consumeInvitation consumes the invitation identified by "invitationCode",
+// ensuring it was issued for the given email address.
+// It returns codes.ErrCodeNotFound if there is no such valid invitation.
+func consumeInvitation(ctx *HandlerContext, invitationCode string, email string) (*admins.Invitation, error) {
+	invitation := &admins.Invitation{}
+	err := consumeSignedCode(ctx, ctx.invitationStore, invitationCode, invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.Email != admins.NormalizeEmail(email) {
+		restoreInvitation(ctx, invitationCode, invitation)
+		return nil, codes.ErrCodeNotFound
+	}
+
+	return invitation, nil
+}
+
+// restoreInvitation puts a consumed invitation back for its remaining lifetime,
+// so that a failed sign-up doesn't use it up.
+func restoreInvitation(ctx *HandlerContext, invitationCode string, invitation *admins.Invitation) {
+	restoreCode(ctx.invitationStore, invitationCode, invitation, invitation.ExpiresAt)
+}
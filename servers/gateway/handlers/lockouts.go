@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefixes of the rate limit keys of sign-in attempts,
+// which are limited per email and per client IP.
+const (
+	EmailKeyPrefix = "email:"
+	IPKeyPrefix    = "ip:"
+)
+
+// loginKeys returns the rate limit keys of a sign-in attempt
+// for the given email from the client of "r".
+func loginKeys(email string, r *http.Request) (emailKey string, ipKey string) {
+	return EmailKeyPrefix + admins.NormalizeEmail(email), IPKeyPrefix + getClientIP(r)
+}
+
+// tooManyRequests responds with an http.StatusTooManyRequests error
+// telling the client how many seconds to wait before trying again.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set(headerRetryAfter, strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too many failed attempts, try again in %d seconds", seconds), http.StatusTooManyRequests)
+}
+
+// loginFailed records a failed sign-in attempt for the given keys
+// and responds with an http.StatusUnauthorized error.
+func loginFailed(ctx *HandlerContext, w http.ResponseWriter, keys ...string) {
+	err := ctx.loginLimiter.Fail(keys...)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error recording failed attempt: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, invalidCredentials, http.StatusUnauthorized)
+}
+
+// loginSucceeded clears the failed attempts against the email
+// and takes back the attempt counted against the client IP.
+// The other attempts of the IP are kept, so that signing in to one account
+// doesn't clear the failed attempts made against others from the same IP.
+func loginSucceeded(ctx *HandlerContext, emailKey string, ipKey string) error {
+	err := ctx.loginLimiter.Reset(emailKey)
+	if err != nil {
+		return err
+	}
+	return ctx.loginLimiter.Forgive(ipKey)
+}
+
+// LockoutsHandler handles requests for the "lockouts" resource,
+// and allows admins to see which emails and client IPs
+// are locked out after too many failed sign-in attempts.
+func (ctx *HandlerContext) LockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be GET.
+	if r.Method != "GET" {
+		http.Error(w, "Expect GET method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	_, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// Only owners and managers can see lockouts.
+	if !sessionState.Admin.Role.AtLeast(admins.RoleManager) {
+		http.Error(w, "Only owners and managers can see lockouts", http.StatusForbidden)
+		return
+	}
+
+	lockouts, err := ctx.loginLimiter.Lockouts()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting lockouts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add(headerContentType, contentTypeJSON)
+	err = json.NewEncoder(w).Encode(lockouts)
+	if err != nil {
+		http.Error(w, "Error encoding Lockout structs to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SpecificLockoutHandler handles requests for a specific lockout resource,
+// and allows admins to clear the failed sign-in attempts of an email or client IP.
+// The resource path is /v1/lockouts/{key},
+// where key is either "email:{email}" or "ip:{ip}".
+func (ctx *HandlerContext) SpecificLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be DELETE.
+	if r.Method != "DELETE" {
+		http.Error(w, "Expect DELETE method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	_, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// Only owners and managers can clear lockouts.
+	if !sessionState.Admin.Role.AtLeast(admins.RoleManager) {
+		http.Error(w, "Only owners and managers can clear lockouts", http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/v1/lockouts/")
+	if !strings.HasPrefix(key, EmailKeyPrefix) && !strings.HasPrefix(key, IPKeyPrefix) {
+		http.Error(w, "Lockout key must start with \"email:\" or \"ip:\"", http.StatusBadRequest)
+		return
+	}
+
+	err = ctx.loginLimiter.Reset(key)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error clearing lockout: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Lockout cleared"))
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
+	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// resetCodeDuration is how long a reset code stays valid.
+const resetCodeDuration = time.Minute * 15
+
+// ResetCodeRequest represents an admin asking for a password reset code.
+type ResetCodeRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetCodesHandler handles requests for the "reset codes" resource,
+// and allows clients to have a password reset code sent to an admin's email.
+func (ctx *HandlerContext) ResetCodesHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be POST.
+	if r.Method != "POST" {
+		http.Error(w, "Expect POST method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resetCodeRequest := &ResetCodeRequest{}
+	err := json.NewDecoder(r.Body).Decode(resetCodeRequest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Respond the same way whether or not the admin exists,
+	// so that clients can't find out which emails have accounts.
+	admin, err := ctx.adminStore.GetByEmail(admins.NormalizeEmail(resetCodeRequest.Email))
+	if err == admins.ErrAdminNotFound {
+		w.Write([]byte("Reset code sent"))
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting admin from admin store: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Save the email the reset code is issued for,
+	// so that it can't be used to reset someone else's password.
+	resetCode, err := issueCode(ctx, ctx.resetCodeStore, admin.Email, resetCodeDuration)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error issuing reset code: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	body := fmt.Sprintf(
+		"Your password reset code is:\n\n%s\n\nIt expires in %v.",
+		resetCode,
+		resetCodeDuration,
+	)
+	err = ctx.mailer.Send(admin.Email, "Visitorex password reset", body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error sending reset code: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Reset code sent"))
+}
+
+// PasswordsHandler handles requests for the "passwords" resource,
+// and allows clients to reset an admin's password using a reset code.
+// The resource path is /v1/passwords/{email}.
+func (ctx *HandlerContext) PasswordsHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be PUT.
+	if r.Method != "PUT" {
+		http.Error(w, "Expect PUT method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Emails are stored normalized,
+	// so normalize this one before it is compared or looked up.
+	email := admins.NormalizeEmail(strings.TrimPrefix(r.URL.Path, "/v1/passwords/"))
+	if len(email) == 0 {
+		http.Error(w, "Email must be provided in resource path", http.StatusBadRequest)
+		return
+	}
+
+	passwordReset := &admins.PasswordReset{}
+	err := json.NewDecoder(r.Body).Decode(passwordReset)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = passwordReset.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Consume the reset code, so that it can be used only once.
+	resetCodeEmail := ""
+	err = consumeSignedCode(ctx, ctx.resetCodeStore, passwordReset.ResetCode, &resetCodeEmail)
+	if err == codes.ErrCodeNotFound {
+		http.Error(w, "Invalid reset code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error consuming reset code: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if resetCodeEmail != email {
+		http.Error(w, "Invalid reset code", http.StatusUnauthorized)
+		return
+	}
+
+	admin, err := ctx.adminStore.GetByEmail(email)
+	if err == admins.ErrAdminNotFound {
+		http.Error(w, "Admin not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting admin from admin store: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = admin.SetPassword(passwordReset.Password)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error setting password: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = ctx.adminStore.SetPassHash(admin.ID, admin.PassHash)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Sign out everywhere, so that whoever
+	// might have known the old password loses access.
+	err = endAdminSessions(ctx, admin.ID, sessions.InvalidSessionToken)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error ending sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("Password reset"))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"net/http"
+	"regexp"
+)
+
+// Policy maps a route to the roles allowed to use it.
+type Policy struct {
+	PathPattern *regexp.Regexp
+	// Methods the policy applies to.
+	// If empty, the policy applies to all methods.
+	Methods []string
+	// Roles allowed to use the route.
+	Roles []admins.Role
+}
+
+// NewPolicy creates a new Policy.
+// It panics if "pathPattern" is not a valid regular expression.
+func NewPolicy(pathPattern string, methods []string, roles ...admins.Role) *Policy {
+	return &Policy{regexp.MustCompile(pathPattern), methods, roles}
+}
+
+// appliesTo returns whether the policy applies to the request.
+func (policy *Policy) appliesTo(r *http.Request) bool {
+	if !policy.PathPattern.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(policy.Methods) == 0 {
+		return true
+	}
+	for _, method := range policy.Methods {
+		if method == r.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// allows returns whether the policy allows the role to use the route.
+func (policy *Policy) allows(role admins.Role) bool {
+	for _, allowedRole := range policy.Roles {
+		if allowedRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyHandler is a middleware handler that only lets
+// admins whose role is allowed by every policy applying to
+// the request through to the wrapped handler.
+type PolicyHandler struct {
+	handler  http.Handler
+	policies []*Policy
+	ctx      *HandlerContext
+}
+
+// NewPolicyHandler wraps another handler into PolicyHandler.
+func NewPolicyHandler(handlerToWrap http.Handler, policies []*Policy, ctx *HandlerContext) *PolicyHandler {
+	return &PolicyHandler{handlerToWrap, policies, ctx}
+}
+
+// ServeHTTP is a method of PolicyHandler.
+// Now our PolicyHandler is a http.Handler.
+func (ph *PolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Only look up the session state
+	// if there is a policy applying to this request.
+	var sessionState *SessionState
+	for _, policy := range ph.policies {
+		if !policy.appliesTo(r) {
+			continue
+		}
+
+		if sessionState == nil {
+			sessionState = &SessionState{}
+			_, err := sessions.GetState(r, ph.ctx.keyring, ph.ctx.transport, ph.ctx.sessionStore, sessionState)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if !policy.allows(sessionState.Admin.Role) {
+			http.Error(w, fmt.Sprintf("Role %s is not allowed to %s %s", sessionState.Admin.Role, r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
+	}
+
+	ph.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// parsePattern returns the parts the path pattern is a concatenation of.
+func parsePattern(pattern string) []*syntax.Regexp {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+	if re.Op == syntax.OpConcat {
+		return re.Sub
+	}
+	return []*syntax.Regexp{re}
+}
+
+// isAnchored returns whether the pattern only matches at the beginning of the path.
+// Patterns anchored with (?m)^ don't count, since decoded paths may contain newlines.
+func isAnchored(pattern string) bool {
+	subs := parsePattern(pattern)
+	return len(subs) != 0 && subs[0].Op == syntax.OpBeginText
+}
+
+// literalPrefix returns the literal string every path
+// matching the pattern must begin with.
+// Unanchored patterns can match anywhere in the path,
+// so their prefix is always empty.
+// The standard regexp.LiteralPrefix gives up on some anchored patterns,
+// so the prefix is read from the parsed pattern instead.
+func literalPrefix(pattern string) string {
+	if !isAnchored(pattern) {
+		return ""
+	}
+
+	prefix := ""
+	for _, sub := range parsePattern(pattern)[1:] {
+		switch {
+		case sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0:
+			prefix += string(sub.Rune)
+		default:
+			return prefix
+		}
+	}
+	return prefix
+}
+
+// updateRoutes rebuilds the routing table of the service list,
+// so that requests are matched against anchored patterns first,
+// since unanchored ones can match anywhere in the path,
+// then against the service with the longest literal prefix,
+// then the highest priority, and then by name,
+// no matter in what order services registered.
+// The caller must hold the lock of the ServiceList.
+func (serviceList *ServiceList) updateRoutes() {
+	routes := make([]*service, 0, len(serviceList.services))
+	for _, svc := range serviceList.services {
+		routes = append(routes, svc)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if a.anchored != b.anchored {
+			return a.anchored
+		}
+		if len(a.literalPrefix) != len(b.literalPrefix) {
+			return len(a.literalPrefix) > len(b.literalPrefix)
+		}
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.name < b.name
+	})
+	serviceList.routes = routes
+}
+
+// warnOverlaps logs a warning for every other service
+// whose path pattern might match the same paths as those of "svc",
+// and which one of them takes precedence.
+// Anchored patterns can only both match a path if one literal prefix
+// is a prefix of the other, but unanchored patterns might match
+// the same paths as any other pattern.
+// The caller must hold the lock of the ServiceList,
+// and the routing table must be up to date.
+func (serviceList *ServiceList) warnOverlaps(svc *service) {
+	rank := make(map[*service]int, len(serviceList.routes))
+	for i, route := range serviceList.routes {
+		rank[route] = i
+	}
+	for _, other := range serviceList.routes {
+		if other == svc {
+			continue
+		}
+		if svc.anchored && other.anchored &&
+			!strings.HasPrefix(svc.literalPrefix, other.literalPrefix) &&
+			!strings.HasPrefix(other.literalPrefix, svc.literalPrefix) {
+			continue
+		}
+		winner := svc
+		if rank[other] < rank[svc] {
+			winner = other
+		}
+		log.Printf("Warning: path pattern %q of microservice %s might overlap with %q of microservice %s, %s takes precedence\n",
+			svc.pathPatternRegexp.String(), svc.name, other.pathPatternRegexp.String(), other.name, winner.name)
+	}
+}
+
+// checkReservedPaths returns an error if the path pattern
+// might match any of the reserved paths or the paths beneath them.
+// Only the pattern's literal prefix is compared against the reserved paths,
+// since whatever follows it might match anything, so patterns must be
+// anchored and begin with a literal prefix.
+func checkReservedPaths(pattern string, reservedPaths []string) error {
+	if !isAnchored(pattern) {
+		return fmt.Errorf("Path pattern %q must be anchored with ^", pattern)
+	}
+	prefix := literalPrefix(pattern)
+	if len(prefix) == 0 {
+		return fmt.Errorf("Path pattern %q must begin with a literal path after ^", pattern)
+	}
+	for _, reservedPath := range reservedPaths {
+		reservedPath = strings.TrimSuffix(reservedPath, "/")
+		// Every path the pattern matches is the reserved path or beneath it,
+		// or the pattern might match the reserved path.
+		if prefix == reservedPath ||
+			strings.HasPrefix(prefix, reservedPath+"/") ||
+			strings.HasPrefix(reservedPath, prefix) {
+			return fmt.Errorf("Path pattern %q overlaps gateway route %s", pattern, reservedPath)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import "testing"
+
+func TestIsAnchored(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		expected bool
+	}{
+		{"^/v1/offices", true},
+		{`\A/v1/offices`, true},
+		{"/v1/offices", false},
+		{"(?m)^/v1/offices", false},
+		{"^/v1/offices|/v1/sessions", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if anchored := isAnchored(c.pattern); anchored != c.expected {
+			t.Errorf("isAnchored(%q): expected %t but got %t", c.pattern, c.expected, anchored)
+		}
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		expected string
+	}{
+		{"^/v1/offices", "/v1/offices"},
+		{"^/v1/offices/?", "/v1/offices"},
+		{"^/v1/offices/[0-9a-f]{24}$", "/v1/offices/"},
+		{"^/v1/(sessions|x)/[0-9a-f]{32}$", "/v1/"},
+		{"^/v1/(?i)sessions", "/v1/"},
+		{"^(?i)/v1/sessions", ""},
+		{"^.*", ""},
+		{"/v1/offices", ""},
+		{"(?m)^/v1/offices", ""},
+	}
+	for _, c := range cases {
+		if prefix := literalPrefix(c.pattern); prefix != c.expected {
+			t.Errorf("literalPrefix(%q): expected %q but got %q", c.pattern, c.expected, prefix)
+		}
+	}
+}
+
+func TestCheckReservedPaths(t *testing.T) {
+	reservedPaths := []string{"/v1/admins", "/v1/admins/", "/v1/sessions", "/v1/sessions/", "/v1/ws"}
+	cases := []struct {
+		pattern   string
+		expectErr bool
+	}{
+		{"^/v1/offices", false},
+		{"^/v1/offices/[0-9a-f]{24}$", false},
+		{"^/v1/wsx", false},
+		{"^/v1/sessions", true},
+		{"^/v1/sessions/[0-9a-f]{32}$", true},
+		{"^/v1/(sessions|x)/[0-9a-f]{32}$", true},
+		{"^/v1/(?i)SESSIONS", true},
+		{"^/v1/", true},
+		{"^/v1/w", true},
+		{"^/v1/ws", true},
+		{"^/v1/admins/me", true},
+		{"^.*", true},
+		{"/v1/offices", true},
+		{"(?m)^/v1/offices", true},
+		{".*sessions", true},
+	}
+	for _, c := range cases {
+		err := checkReservedPaths(c.pattern, reservedPaths)
+		if c.expectErr && err == nil {
+			t.Errorf("checkReservedPaths(%q): expected an error but got none", c.pattern)
+		}
+		if !c.expectErr && err != nil {
+			t.Errorf("checkReservedPaths(%q): unexpected error: %v", c.pattern, err)
+		}
+	}
+}
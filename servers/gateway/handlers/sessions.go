@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionInfo represents one of an admin's active sessions.
+// It never contains the session token itself.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	BeginTime time.Time `json:"beginTime"`
+	ClientIP  string    `json:"clientIP"`
+	UserAgent string    `json:"userAgent"`
+	// Current is true for the session the request was made with.
+	Current bool `json:"current"`
+}
+
+// listSessions responds with the current admin's active sessions,
+// encoded as a JSON array.
+func listSessions(ctx *HandlerContext, w http.ResponseWriter, r *http.Request) {
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	sessionID, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	sessionTokens, err := ctx.sessionStore.Tokens(sessionState.Admin.ID.Hex())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	sessionInfos := []*SessionInfo{}
+	for _, sessionToken := range sessionTokens {
+		// Peek rather than Get, so that listing sessions
+		// doesn't keep them from expiring.
+		state := &SessionState{}
+		err := ctx.sessionStore.Peek(sessionToken, state)
+		if err == sessions.ErrStateNotFound {
+			continue
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusInternalServerError)
+			return
+		}
+		sessionInfos = append(sessionInfos, &SessionInfo{
+			ID:        sessionToken.ID(),
+			BeginTime: state.BeginTime,
+			ClientIP:  state.ClientIP,
+			UserAgent: state.UserAgent,
+			Current:   sessionToken == sessionID,
+		})
+	}
+
+	w.Header().Add(headerContentType, contentTypeJSON)
+	err = json.NewEncoder(w).Encode(sessionInfos)
+	if err != nil {
+		http.Error(w, "Error encoding SessionInfo structs to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SpecificSessionHandler handles requests for a specific session resource,
+// and allows clients to end one of the current admin's sessions.
+// The resource path is /v1/sessions/{id}.
+func (ctx *HandlerContext) SpecificSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be DELETE.
+	if r.Method != "DELETE" {
+		http.Error(w, "Expect DELETE method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	_, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/sessions/")
+
+	// Only look among the current admin's sessions,
+	// so that admins can't end each other's sessions.
+	sessionTokens, err := ctx.sessionStore.Tokens(sessionState.Admin.ID.Hex())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	for _, sessionToken := range sessionTokens {
+		if sessionToken.ID() != id {
+			continue
+		}
+		err = ctx.sessionStore.Delete(sessionToken)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error ending session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("Session ended"))
+		return
+	}
+
+	http.Error(w, "Session not found", http.StatusNotFound)
+}
+
+// SessionsAllHandler handles requests for the "all sessions" resource,
+// and allows clients to end every session of the current admin.
+// If the "keepCurrent" query string parameter is "true",
+// the session the request was made with is kept.
+func (ctx *HandlerContext) SessionsAllHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be DELETE.
+	if r.Method != "DELETE" {
+		http.Error(w, "Expect DELETE method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	sessionID, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	keep := sessions.InvalidSessionToken
+	if r.URL.Query().Get("keepCurrent") == "true" {
+		keep = sessionID
+	}
+
+	err = endAdminSessions(ctx, sessionState.Admin.ID, keep)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error ending sessions: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if keep == sessions.InvalidSessionToken {
+		ctx.transport.ClearToken(w)
+	}
+
+	w.Write([]byte("Signed out everywhere"))
+}
+
+// getClientIP returns the IP address of the client making the request.
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -9,5 +9,11 @@ import (
 type SessionState struct {
 	// Time struct should be passed as value not pointer.
 	BeginTime time.Time
-	Admin     *admins.Admin
+	// Admin carries the admin's Role,
+	// which is used to authorize requests.
+	Admin *admins.Admin
+	// IP address and user agent of the client
+	// that began the session.
+	ClientIP  string
+	UserAgent string
 }
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"time"
+)
+
+// issueCode saves "value" to the code store under a new code,
+// which expires after "ttl", and returns the code.
+// Codes are signed the same way as session tokens,
+// so that forged codes can be rejected without touching the store.
+func issueCode(ctx *HandlerContext, store codes.Store, value interface{}, ttl time.Duration) (string, error) {
+	code, err := sessions.NewSessionToken(ctx.keyring)
+	if err != nil {
+		return "", fmt.Errorf("error creating code: %v", err)
+	}
+
+	err = store.Save(code.String(), value, ttl)
+	if err != nil {
+		return "", fmt.Errorf("error saving code: %v", err)
+	}
+
+	return code.String(), nil
+}
+
+// consumeSignedCode populates "value" with the data saved for a code
+// issued by issueCode, and deletes the code from the code store.
+// Codes that weren't signed by us are rejected
+// without touching the store, with codes.ErrCodeNotFound.
+func consumeSignedCode(ctx *HandlerContext, store codes.Store, code string, value interface{}) error {
+	validCode, err := sessions.ValidateToken(code, ctx.keyring)
+	if err != nil {
+		return codes.ErrCodeNotFound
+	}
+
+	return store.Consume(validCode.String(), value)
+}
+
+// restoreCode puts a consumed code back for the rest of its lifetime,
+// so that a failed attempt doesn't use it up.
+func restoreCode(store codes.Store, code string, value interface{}, expiresAt time.Time) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return
+	}
+	store.Save(code, value, ttl)
+}
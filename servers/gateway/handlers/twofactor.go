@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/sessions"
+	"github.com/zicodeng/visitorex/servers/gateway/totp"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"time"
+)
+
+// totpIssuer is the name authenticator apps show next to the codes.
+const totpIssuer = "Visitorex"
+
+// pendingLoginDuration is how long an admin has to enter
+// their second factor after entering their password.
+const pendingLoginDuration = time.Minute * 5
+
+// TOTPEnrollment represents the secret of an admin
+// who is enrolling in two-factor authentication.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodes represents the recovery codes of an admin,
+// which are only ever shown once.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// PendingSession represents a sign-in whose password has been verified,
+// but whose second factor has yet to be provided.
+type PendingSession struct {
+	PendingToken string    `json:"pendingToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// pendingLogin is the data saved for a pending session.
+type pendingLogin struct {
+	AdminID   bson.ObjectId `json:"adminID"`
+	Email     string        `json:"email"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+}
+
+// AdminsMeTOTPHandler handles requests for the "current admin's two-factor authentication" resource,
+// and allows clients to enroll (POST), confirm the enrollment (PUT),
+// and disable two-factor authentication (DELETE).
+// Every one of them requires the admin's password,
+// so that a stolen session can't take over the second factor.
+func (ctx *HandlerContext) AdminsMeTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	sessionID, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// The TOTP secret is not kept in the session state,
+	// so get the admin from the admin store.
+	admin, err := ctx.adminStore.GetByID(sessionState.Admin.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting admin from admin store: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+
+	// Generate a new secret after re-confirming the admin's password,
+	// and respond with it, so that the admin can add it to their authenticator app.
+	case "POST":
+		passwordConfirmation := &admins.PasswordConfirmation{}
+		err := json.NewDecoder(r.Body).Decode(passwordConfirmation)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = authenticate(ctx, admin, passwordConfirmation.Password)
+		if err != nil {
+			http.Error(w, invalidCredentials, http.StatusUnauthorized)
+			return
+		}
+
+		if admin.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error generating secret: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		err = ctx.adminStore.SetTOTP(admin.ID, secret, false, nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add(headerContentType, contentTypeJSON)
+		w.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(w).Encode(&TOTPEnrollment{
+			Secret: secret,
+			URI:    totp.URI(totpIssuer, admin.Email, secret),
+		})
+		if err != nil {
+			http.Error(w, "Error encoding TOTPEnrollment struct to JSON", http.StatusInternalServerError)
+			return
+		}
+
+	// Enable two-factor authentication once the admin re-confirms their password
+	// and proves their authenticator app has the secret,
+	// end the admin's other sessions, and respond with a new set of recovery codes.
+	case "PUT":
+		totpConfirmation := &admins.TOTPConfirmation{}
+		err := json.NewDecoder(r.Body).Decode(totpConfirmation)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = authenticate(ctx, admin, totpConfirmation.Password)
+		if err != nil {
+			http.Error(w, invalidCredentials, http.StatusUnauthorized)
+			return
+		}
+
+		if admin.TOTPEnabled {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+
+		counter, err := admin.AuthenticateTOTP(totpConfirmation.Code)
+		if err == nil {
+			err = ctx.adminStore.UseTOTPCounter(admin.ID, counter)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error confirming two-factor authentication: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		recoveryCodes, hashes, err := admins.NewRecoveryCodes()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error generating recovery codes: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		err = ctx.adminStore.SetTOTP(admin.ID, admin.TOTPSecret, true, hashes)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		err = setSessionTOTPEnabled(ctx, sessionID, sessionState, true)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error saving updated session state to session store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Other sessions began without the second factor.
+		err = endAdminSessions(ctx, admin.ID, sessionID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error ending other sessions: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Add(headerContentType, contentTypeJSON)
+		err = json.NewEncoder(w).Encode(&RecoveryCodes{RecoveryCodes: recoveryCodes})
+		if err != nil {
+			http.Error(w, "Error encoding RecoveryCodes struct to JSON", http.StatusInternalServerError)
+			return
+		}
+
+	// Disable two-factor authentication after re-confirming the admin's password.
+	case "DELETE":
+		passwordConfirmation := &admins.PasswordConfirmation{}
+		err := json.NewDecoder(r.Body).Decode(passwordConfirmation)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		err = authenticate(ctx, admin, passwordConfirmation.Password)
+		if err != nil {
+			http.Error(w, invalidCredentials, http.StatusUnauthorized)
+			return
+		}
+
+		err = ctx.adminStore.SetTOTP(admin.ID, "", false, nil)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		err = setSessionTOTPEnabled(ctx, sessionID, sessionState, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error saving updated session state to session store: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("Two-factor authentication disabled"))
+
+	// If clients send requests that are neither POST, PUT, nor DELETE...
+	default:
+		http.Error(w, "Expect POST, PUT, or DELETE method only", http.StatusMethodNotAllowed)
+		return
+	}
+}
+
+// SessionsTOTPHandler handles requests for the "second factor" resource,
+// and allows clients to turn a pending session into a full session
+// using a code from the admin's authenticator app or a recovery code.
+func (ctx *HandlerContext) SessionsTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be POST.
+	if r.Method != "POST" {
+		http.Error(w, "Expect POST method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secondFactor := &admins.SecondFactor{}
+	err := json.NewDecoder(r.Body).Decode(secondFactor)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = secondFactor.Validate()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error validating second factor: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	pendingToken := secondFactor.PendingToken
+	login := &pendingLogin{}
+	err = consumeSignedCode(ctx, ctx.pendingLoginStore, pendingToken, login)
+	if err != nil {
+		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	// Guessing codes counts against the same limits as guessing passwords.
+	// Refused attempts put the pending login back,
+	// so that the admin can try again once the lockout is over.
+	emailKey, ipKey := loginKeys(login.Email, r)
+	retryAfter, err := ctx.loginLimiter.Attempt(emailKey, ipKey)
+	if err != nil {
+		restorePendingLogin(ctx, pendingToken, login)
+		http.Error(w, fmt.Sprintf("Error counting attempt: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		restorePendingLogin(ctx, pendingToken, login)
+		tooManyRequests(w, retryAfter)
+		return
+	}
+
+	admin, err := ctx.adminStore.GetByID(login.AdminID)
+	if err != nil {
+		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	if len(secondFactor.RecoveryCode) != 0 {
+		err = ctx.adminStore.ConsumeRecoveryCode(admin.ID, admins.HashRecoveryCode(secondFactor.RecoveryCode))
+		if err != nil && err != admins.ErrRecoveryCodeNotFound {
+			http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		var counter uint64
+		counter, err = admin.AuthenticateTOTP(secondFactor.Code)
+		if err == nil {
+			err = ctx.adminStore.UseTOTPCounter(admin.ID, counter)
+			if err != nil && err != admins.ErrTOTPCodeUsed {
+				http.Error(w, fmt.Sprintf("Error updating admin store: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if err != nil {
+		// Let the admin try again without re-entering their password,
+		// for as long as the pending session lasts.
+		restorePendingLogin(ctx, pendingToken, login)
+		loginFailed(ctx, w, emailKey, ipKey)
+		return
+	}
+
+	err = loginSucceeded(ctx, emailKey, ipKey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error resetting failed attempts: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	beginNewSession(ctx, admin, w, r)
+}
+
+// beginPendingSession responds with a pending session for the admin,
+// which must be completed with a second factor through SessionsTOTPHandler.
+func beginPendingSession(ctx *HandlerContext, admin *admins.Admin, w http.ResponseWriter) {
+	login := &pendingLogin{
+		AdminID:   admin.ID,
+		Email:     admin.Email,
+		ExpiresAt: time.Now().Add(pendingLoginDuration),
+	}
+	pendingToken, err := issueCode(ctx, ctx.pendingLoginStore, login, pendingLoginDuration)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error saving pending session: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add(headerContentType, contentTypeJSON)
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(&PendingSession{
+		PendingToken: pendingToken,
+		ExpiresAt:    login.ExpiresAt,
+	})
+	if err != nil {
+		http.Error(w, "Error encoding PendingSession struct to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// restorePendingLogin puts a consumed pending login back for its remaining lifetime.
+func restorePendingLogin(ctx *HandlerContext, pendingToken string, login *pendingLogin) {
+	restoreCode(ctx.pendingLoginStore, pendingToken, login, login.ExpiresAt)
+}
+
+// setSessionTOTPEnabled updates the admin kept in the session state,
+// so that it reflects whether two-factor authentication is enabled.
+func setSessionTOTPEnabled(ctx *HandlerContext, sessionID sessions.SessionToken, sessionState *SessionState, enabled bool) error {
+	sessionState.Admin.TOTPEnabled = enabled
+	return ctx.sessionStore.Save(sessionID, sessionState)
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/zicodeng/visitorex/servers/gateway/sessions"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -32,12 +34,14 @@ func (ctx *HandlerContext) NewWebSocketsHandler(notifier *Notifier) *WebSocketsH
 // ServeHTTP implements the http.Handler interface for the WebSocketsHandler.
 func (wsh *WebSocketsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Users must be authenticated to upgrade to a WebSocket.
-	// if we get an error when retrieving the session state,
+	// Browsers can't set headers on WebSocket requests,
+	// so the session is identified by a ticket in the query string
+	// rather than by the session token itself.
+	// If we get an error when redeeming the ticket,
 	// respond with an http.StatusUnauthorized.
-	sessionState := &SessionState{}
-	_, err := sessions.GetState(r, wsh.ctx.signingKey, wsh.ctx.sessionStore, sessionState)
+	_, err := redeemWebSocketTicket(wsh.ctx, r.URL.Query().Get(paramTicket))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		http.Error(w, fmt.Sprintf("Error redeeming ticket: %v", err), http.StatusUnauthorized)
 		return
 	}
 
@@ -55,6 +59,75 @@ func (wsh *WebSocketsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	wsh.notifier.AddClient(conn)
 }
 
+// paramTicket is the query string parameter carrying a WebSocket ticket.
+const paramTicket = "ticket"
+
+// ticketDuration is how long a WebSocket ticket stays valid.
+const ticketDuration = time.Second * 30
+
+// WebSocketTicket represents a short-lived, single-use ticket
+// that allows the holder to open a WebSocket for the session it is bound to.
+type WebSocketTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// WebSocketTicketsHandler handles requests for the "WebSocket tickets" resource,
+// and allows clients to get a ticket for opening a WebSocket.
+func (ctx *HandlerContext) WebSocketTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	// Method must be POST.
+	if r.Method != "POST" {
+		http.Error(w, "Expect POST method only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get session state from session store.
+	sessionState := &SessionState{}
+	sessionID, err := sessions.GetState(r, ctx.keyring, ctx.transport, ctx.sessionStore, sessionState)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error getting session state: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// Bind the ticket to the current session.
+	ticket, err := issueCode(ctx, ctx.ticketStore, sessionID, ticketDuration)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error issuing ticket: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add(headerContentType, contentTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+
+	err = json.NewEncoder(w).Encode(&WebSocketTicket{
+		Ticket:    ticket,
+		ExpiresAt: time.Now().Add(ticketDuration),
+	})
+	if err != nil {
+		http.Error(w, "Error encoding WebSocketTicket struct to JSON", http.StatusInternalServerError)
+		return
+	}
+}
+
+// redeemWebSocketTicket consumes the ticket and returns the state
+// of the session it is bound to, if that session is still active.
+func redeemWebSocketTicket(ctx *HandlerContext, ticket string) (*SessionState, error) {
+	var sessionID sessions.SessionToken
+	err := consumeSignedCode(ctx, ctx.ticketStore, ticket, &sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The session might have ended since the ticket was issued.
+	sessionState := &SessionState{}
+	err = ctx.sessionStore.Get(sessionID, sessionState)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionState, nil
+}
+
 // Notifier is an object that handles WebSocket notifications.
 type Notifier struct {
 	clients       []*websocket.Conn
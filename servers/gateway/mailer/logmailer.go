@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// LogMailer implements Mailer by writing messages to a log
+// instead of delivering them. It is meant for local use.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+// NewLogMailer constructs a new LogMailer
+// that writes messages to "out", typically a log file.
+func NewLogMailer(out io.Writer) *LogMailer {
+	if out == nil {
+		panic("Nil writer")
+	}
+	return &LogMailer{
+		logger: log.New(out, "", log.LstdFlags),
+	}
+}
+
+// Send writes the message to the log.
+func (lm *LogMailer) Send(to string, subject string, body string) error {
+	err := lm.logger.Output(2, fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", to, subject, body))
+	if err != nil {
+		return fmt.Errorf("error writing message to log: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package mailer
+
+// Mailer represents a way of delivering email messages.
+// This is an abstract interface that can be implemented
+// against several different delivery mechanisms. For example,
+// messages could be sent through an SMTP server,
+// or simply written to a log file during local development.
+type Mailer interface {
+	// Send sends a message with the given subject and body
+	// to the "to" email address.
+	Send(to string, subject string, body string) error
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
-	"encoding/json"
 	"github.com/go-redis/redis"
 	"github.com/streadway/amqp"
+	"github.com/zicodeng/visitorex/servers/gateway/codes"
 	"github.com/zicodeng/visitorex/servers/gateway/handlers"
+	"github.com/zicodeng/visitorex/servers/gateway/mailer"
 	"github.com/zicodeng/visitorex/servers/gateway/models/admins"
+	"github.com/zicodeng/visitorex/servers/gateway/ratelimit"
 	"github.com/zicodeng/visitorex/servers/gateway/sessions"
 	"gopkg.in/mgo.v2"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,12 +31,52 @@ func main() {
 		log.Fatal("Please set TLS_CERT and TLS_KEY environment variables")
 	}
 
-	// sessionKey is the signing key for session token.
+	// sessionKey is the current signing key for session token.
 	sessionKey := os.Getenv("SESSION_KEY")
 	if len(sessionKey) == 0 {
 		log.Fatal("Please set SESSION_KEY environment variable")
 	}
 
+	// Previous signing keys, separated by commas and ordered from newest to oldest.
+	// Session tokens signed with them are still valid,
+	// so that rotating SESSION_KEY doesn't end every session.
+	previousSessionKeys := []string{}
+	if keys := os.Getenv("PREVIOUS_SESSION_KEYS"); len(keys) != 0 {
+		previousSessionKeys = strings.Split(keys, ",")
+	}
+
+	keyring, err := sessions.NewKeyring(sessionKey, previousSessionKeys...)
+	if err != nil {
+		log.Fatalf("Error creating keyring: %v", err)
+	}
+
+	// Key shared with microservices to sign their announcements,
+	// so that other processes with access to Redis can't register
+	// themselves as microservices.
+	serviceKey := os.Getenv("SERVICE_KEY")
+	if len(serviceKey) == 0 {
+		log.Fatal("Please set SERVICE_KEY environment variable")
+	}
+	// Microservices must not be able to sign session tokens.
+	if serviceKey == sessionKey {
+		log.Fatal("SERVICE_KEY must be different from SESSION_KEY")
+	}
+	for _, key := range previousSessionKeys {
+		if serviceKey == key {
+			log.Fatal("SERVICE_KEY must be different from PREVIOUS_SESSION_KEYS")
+		}
+	}
+
+	// How far the timestamp of an announcement may be from now.
+	// If empty, default to 30 seconds.
+	announcementSkew := time.Second * 30
+	if skew := os.Getenv("SERVICE_ANNOUNCEMENT_SKEW"); len(skew) != 0 {
+		announcementSkew, err = time.ParseDuration(skew)
+		if err != nil {
+			log.Fatalf("Error parsing SERVICE_ANNOUNCEMENT_SKEW: %v", err)
+		}
+	}
+
 	dbName := os.Getenv("DB_NAME")
 	if len(dbName) == 0 {
 		log.Fatal("Please set DB_NAME environment variable")
@@ -67,48 +111,292 @@ func main() {
 		Addr: redisAddr,
 	})
 
-	// Create a shared Mongo session.
-	mongoSession, err := mgo.Dial(mongoAddr)
-	if err != nil {
-		log.Fatalf("Error dialing mongo: %v", err)
+	// Initialize transport for session tokens.
+	// Session tokens are sent in the Authorization header
+	// unless SESSION_TRANSPORT is set to "cookie".
+	// SESSION_COOKIE_DOMAIN lets a client on another host
+	// of the same domain read the CSRF cookie.
+	var transport sessions.Transport
+	switch os.Getenv("SESSION_TRANSPORT") {
+	case "cookie":
+		transport = sessions.NewCookieTransport("session", "csrf", os.Getenv("SESSION_COOKIE_DOMAIN"))
+	default:
+		transport = &sessions.HeaderTransport{}
+	}
+
+	// The only origin allowed to make credentialed cross-origin requests,
+	// which cookie-based sessions need.
+	// If empty, any origin is allowed without credentials.
+	corsOrigin := os.Getenv("CORS_ORIGIN")
+	// Browsers refuse credentialed responses allowing any origin,
+	// so cookie-based sessions can't work without it.
+	if os.Getenv("SESSION_TRANSPORT") == "cookie" && len(corsOrigin) == 0 {
+		log.Fatal("Please set CORS_ORIGIN environment variable when SESSION_TRANSPORT is cookie")
+	}
+
+	// Absolute maximum age of a session, no matter how recently it has been used.
+	// If empty, default to 24 hours.
+	sessionMaxAge := time.Hour * 24
+	if maxAge := os.Getenv("SESSION_MAX_AGE"); len(maxAge) != 0 {
+		sessionMaxAge, err = time.ParseDuration(maxAge)
+		if err != nil {
+			log.Fatalf("Error parsing SESSION_MAX_AGE: %v", err)
+		}
 	}
 
-	// Initialize Redis store for session state.
-	sessionStore := sessions.NewRedisStore(redisClient, time.Hour)
+	// Cost of bcrypt password hashes.
+	// Hashes with a different cost are upgraded as admins sign in.
+	// If empty, default to admins.DefaultBcryptCost.
+	if cost := os.Getenv("BCRYPT_COST"); len(cost) != 0 {
+		bcryptCost, err := strconv.Atoi(cost)
+		if err != nil {
+			log.Fatalf("Error parsing BCRYPT_COST: %v", err)
+		}
+		err = admins.SetBcryptCost(bcryptCost)
+		if err != nil {
+			log.Fatalf("Error setting bcrypt cost: %v", err)
+		}
+	}
 
-	// Initialize Mongo store for admins.
-	adminStore := admins.NewMongoStore(mongoSession, dbName, "admins")
+	// Initialize store for session state.
+	// Redis is used unless SESSION_STORE is set to "memory".
+	var sessionStore sessions.Store
+	switch os.Getenv("SESSION_STORE") {
+	case "memory":
+		sessionStore = sessions.NewMemStore(time.Hour, sessionMaxAge, time.Minute)
+	default:
+		redisStore := sessions.NewRedisStore(redisClient, time.Hour, sessionMaxAge)
+		// Sessions without a mark of when they began are treated as expired,
+		// so mark the ones saved before marks existed as beginning now.
+		migrated, err := redisStore.MigrateBeginMarks()
+		if err != nil {
+			log.Fatalf("Error migrating sessions: %v", err)
+		}
+		if migrated > 0 {
+			log.Printf("Marked %d existing sessions as beginning now\n", migrated)
+		}
+		sessionStore = redisStore
+	}
+
+	// Initialize store for admins.
+	// MongoDB is used unless ADMIN_STORE is set to "memory".
+	var adminStore admins.Store
+	switch os.Getenv("ADMIN_STORE") {
+	case "memory":
+		adminStore = admins.NewMemStore()
+	default:
+		// Create a shared Mongo session.
+		mongoSession, err := mgo.Dial(mongoAddr)
+		if err != nil {
+			log.Fatalf("Error dialing mongo: %v", err)
+		}
+		adminStore, err = admins.NewMongoStore(mongoSession, dbName, "admins")
+		if err != nil {
+			log.Fatalf("Error initializing admin store: %v", err)
+		}
+	}
+
+	// Initialize stores for password reset codes, invitations,
+	// WebSocket tickets, and sign-ins waiting for a second factor.
+	// Redis is used unless CODE_STORE is set to "memory".
+	var resetCodeStore, invitationStore, ticketStore, pendingLoginStore codes.Store
+	switch os.Getenv("CODE_STORE") {
+	case "memory":
+		resetCodeStore = codes.NewMemStore(time.Minute)
+		invitationStore = codes.NewMemStore(time.Minute)
+		ticketStore = codes.NewMemStore(time.Minute)
+		pendingLoginStore = codes.NewMemStore(time.Minute)
+	default:
+		resetCodeStore = codes.NewRedisStore(redisClient, "resetCode:")
+		invitationStore = codes.NewRedisStore(redisClient, "invitation:")
+		ticketStore = codes.NewRedisStore(redisClient, "wsTicket:")
+		pendingLoginStore = codes.NewRedisStore(redisClient, "pendingLogin:")
+	}
+
+	// Initialize limiter for sign-in attempts.
+	// After 5 failed attempts within a day, an email is locked out
+	// for 30 seconds, doubling with every further lockout up to an hour.
+	// Many admins may sign in from behind the same NAT,
+	// so a client IP gets many more attempts within a short window,
+	// which is still far too few to guess passwords.
+	loginPolicies := ratelimit.Policies{
+		handlers.EmailKeyPrefix: {
+			Threshold: 5,
+			BaseDelay: time.Second * 30,
+			MaxDelay:  time.Hour,
+			Window:    time.Hour * 24,
+		},
+		handlers.IPKeyPrefix: {
+			Threshold: 100,
+			BaseDelay: time.Minute,
+			MaxDelay:  time.Minute * 15,
+			Window:    time.Minute * 15,
+		},
+	}
+	// Redis is used unless RATE_LIMIT_STORE is set to "memory".
+	var loginLimiter ratelimit.Limiter
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "memory":
+		loginLimiter = ratelimit.NewMemLimiter(loginPolicies, time.Minute)
+	default:
+		loginLimiter = ratelimit.NewRedisLimiter(redisClient, loginPolicies)
+	}
+
+	// Initialize mailer.
+	// Messages contain reset codes and invitation codes,
+	// so they are only logged when MAIL_LOG is explicitly set,
+	// either to a file path or to "stdout".
+	mailLogPath := os.Getenv("MAIL_LOG")
+	if len(mailLogPath) == 0 {
+		log.Fatal("Please set MAIL_LOG environment variable")
+	}
+	mailLog := os.Stdout
+	if mailLogPath != "stdout" {
+		mailLogFile, err := os.OpenFile(mailLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("Error opening mail log: %v", err)
+		}
+		defer mailLogFile.Close()
+		mailLog = mailLogFile
+	}
+	logMailer := mailer.NewLogMailer(mailLog)
 
 	// Initialize HandlerContext.
-	ctx := handlers.NewHandlerContext(sessionKey, sessionStore, adminStore)
+	ctx := handlers.NewHandlerContext(
+		keyring,
+		transport,
+		sessionStore,
+		adminStore,
+		resetCodeStore,
+		invitationStore,
+		ticketStore,
+		pendingLoginStore,
+		loginLimiter,
+		logMailer,
+	)
+
+	// Admins can only sign up with an invitation from an existing admin,
+	// so the very first admin is invited through BOOTSTRAP_ADMIN_EMAIL.
+	// Once there is an owner, the variable is ignored,
+	// so that restarts don't leave owner invitations lying around.
+	if bootstrapEmail := os.Getenv("BOOTSTRAP_ADMIN_EMAIL"); len(bootstrapEmail) != 0 {
+		owners, err := adminStore.CountByRole(admins.RoleOwner)
+		if err != nil {
+			log.Fatalf("Error counting owners: %v", err)
+		}
+		if owners > 0 {
+			log.Println("BOOTSTRAP_ADMIN_EMAIL ignored because an owner already exists")
+		} else {
+			newInvitation := &admins.NewInvitation{
+				Email: bootstrapEmail,
+				Role:  admins.RoleOwner,
+			}
+			_, err := ctx.SendInvitation(newInvitation, "")
+			if err != nil {
+				log.Fatalf("Error inviting bootstrap admin: %v", err)
+			}
+			log.Printf("Sent invitation to bootstrap admin %s\n", bootstrapEmail)
+		}
+	}
 
 	// Initialize notifier.
 	notifier := handlers.NewNotifier()
 
-	pubsub := redisClient.Subscribe(svcChannel)
 	serviceList := handlers.NewServiceList()
-	go listenForServices(pubsub, serviceList)
-	go removeCrashedServices(serviceList)
 
-	// Connect to RabbitMQ server
-	// and continously listen to messages from queue.
-	go listenToMQ(mqAddr, notifier)
+	// Routes served by the gateway itself.
+	// Every one of them is registered on mux
+	// and reserved, so that microservices can't take it over.
+	routes := []struct {
+		path    string
+		handler http.Handler
+	}{
+		{"/v1/admins", http.HandlerFunc(ctx.AdminsHandler)},
+		{"/v1/admins/", http.HandlerFunc(ctx.SpecificAdminHandler)},
+		{"/v1/admins/me", http.HandlerFunc(ctx.AdminsMeHandler)},
+		{"/v1/admins/me/password", http.HandlerFunc(ctx.AdminsMePasswordHandler)},
+		{"/v1/admins/me/totp", http.HandlerFunc(ctx.AdminsMeTOTPHandler)},
+
+		{"/v1/invitations", http.HandlerFunc(ctx.InvitationsHandler)},
+
+		{"/v1/resetcodes", http.HandlerFunc(ctx.ResetCodesHandler)},
+		{"/v1/passwords/", http.HandlerFunc(ctx.PasswordsHandler)},
+
+		{"/v1/sessions", http.HandlerFunc(ctx.SessionsHandler)},
+		{"/v1/sessions/", http.HandlerFunc(ctx.SpecificSessionHandler)},
+		{"/v1/sessions/mine", http.HandlerFunc(ctx.SessionsMineHandler)},
+		{"/v1/sessions/all", http.HandlerFunc(ctx.SessionsAllHandler)},
+		{"/v1/sessions/totp", http.HandlerFunc(ctx.SessionsTOTPHandler)},
+
+		{"/v1/lockouts", http.HandlerFunc(ctx.LockoutsHandler)},
+		{"/v1/lockouts/", http.HandlerFunc(ctx.SpecificLockoutHandler)},
+
+		{"/v1/ws", ctx.NewWebSocketsHandler(notifier)},
+		{"/v1/ws/tickets", http.HandlerFunc(ctx.WebSocketTicketsHandler)},
+
+		{"/v1/services/quarantine", ctx.NewQuarantineHandler(serviceList)},
+	}
 
 	mux := http.NewServeMux()
+	for _, route := range routes {
+		mux.Handle(route.path, route.handler)
+		serviceList.Reserve(route.path)
+	}
 
-	mux.HandleFunc("/v1/admins", ctx.AdminsHandler)
-	mux.HandleFunc("/v1/admins/me", ctx.AdminsMeHandler)
+	// Only start listening for microservices
+	// once every gateway route is reserved.
+	pubsub := redisClient.Subscribe(svcChannel)
+	verifier := handlers.NewAnnouncementVerifier(serviceKey, announcementSkew)
+	go listenForServices(pubsub, verifier, serviceList)
+	go removeCrashedServices(serviceList)
 
-	mux.HandleFunc("/v1/sessions", ctx.SessionsHandler)
-	mux.HandleFunc("/v1/sessions/mine", ctx.SessionsMineHandler)
+	// Connect to RabbitMQ server
+	// and continously listen to messages from queue.
+	go listenToMQ(mqAddr, notifier)
 
-	mux.Handle("/v1/ws", ctx.NewWebSocketsHandler(notifier))
+	// Roles allowed to use routes
+	// served by the gateway and by microservices.
+	policies := []*handlers.Policy{
+		handlers.NewPolicy(
+			"^/v1/invitations$",
+			[]string{"POST"},
+			admins.RoleOwner, admins.RoleManager,
+		),
+		handlers.NewPolicy(
+			"^/v1/admins/[0-9a-f]{24}$",
+			[]string{"DELETE"},
+			admins.RoleOwner,
+		),
+		handlers.NewPolicy(
+			"^/v1/lockouts(/.*)?$",
+			[]string{"GET", "DELETE"},
+			admins.RoleOwner, admins.RoleManager,
+		),
+		handlers.NewPolicy(
+			"^/v1/services/quarantine$",
+			[]string{"GET"},
+			admins.RoleOwner, admins.RoleManager,
+		),
+		handlers.NewPolicy(
+			"^/v1/offices/?$",
+			[]string{"POST"},
+			admins.RoleOwner, admins.RoleManager,
+		),
+		handlers.NewPolicy(
+			"^/v1/offices/[^/]+/?$",
+			[]string{"PATCH", "DELETE"},
+			admins.RoleOwner, admins.RoleManager,
+		),
+	}
 
 	// Chained middlewares.
 	// Wraps mux inside DSDHandler.
 	dsdMux := handlers.NewDSDHandler(mux, serviceList, ctx)
+	// Wraps DSDHandler inside PolicyHandler,
+	// so that policies apply to microservices too.
+	policyMux := handlers.NewPolicyHandler(dsdMux, policies, ctx)
 	// Wraps mux inside CORSHandler.
-	corsMux := handlers.NewCORSHandler(dsdMux)
+	corsMux := handlers.NewCORSHandler(policyMux, corsOrigin)
 
 	log.Printf("Server is listening on https://%s\n", serverAddr)
 	log.Fatal(http.ListenAndServeTLS(serverAddr, TLSCert, TLSKey, corsMux))
@@ -169,7 +457,7 @@ func connectToMQ(addr string) (*amqp.Connection, error) {
 }
 
 // Constantly listen for "Microservices" Redis channel.
-func listenForServices(pubsub *redis.PubSub, serviceList *handlers.ServiceList) {
+func listenForServices(pubsub *redis.PubSub, verifier *handlers.AnnouncementVerifier, serviceList *handlers.ServiceList) {
 	log.Println("Listening for microservices")
 	for {
 		msg, err := receivePubSubMessage(pubsub)
@@ -179,11 +467,15 @@ func listenForServices(pubsub *redis.PubSub, serviceList *handlers.ServiceList)
 			log.Println(err)
 			return
 		}
-		svc := &handlers.ReceivedService{}
-		err = json.Unmarshal([]byte(msg.Payload), svc)
+		// Nothing in an announcement can be trusted
+		// until its signature has been verified.
+		svc, err := verifier.Verify([]byte(msg.Payload), time.Now())
 		if err != nil {
-			log.Printf("Error unmarshalling received microservice JSON to struct: %v", err)
+			serviceList.Reject(nil, err)
+			continue
 		}
+		// Invalid announcements are logged and quarantined by Register,
+		// so that they can't take down the gateway.
 		serviceList.Register(svc)
 	}
 }
@@ -13,17 +13,31 @@ import (
 
 const gravatarBasePhotoURL = "https://www.gravatar.com/avatar/"
 
-var bcryptCost = 13
+// DefaultBcryptCost is the bcrypt cost used unless SetBcryptCost is called.
+const DefaultBcryptCost = 13
+
+var bcryptCost = DefaultBcryptCost
 
 // Admin represents an admin account in the database.
 type Admin struct {
 	ID        bson.ObjectId `json:"id" bson:"_id"`
-	Email     string        `json:"email"`
-	PassHash  []byte        `json:"-"` // Stored, but not encoded to clients.
-	UserName  string        `json:"userName"`
-	FirstName string        `json:"firstName"`
-	LastName  string        `json:"lastName"`
-	PhotoURL  string        `json:"photoURL"`
+	Email     string        `json:"email" bson:"email"`
+	PassHash  []byte        `json:"-" bson:"passHash"` // Stored, but not encoded to clients.
+	UserName  string        `json:"userName" bson:"userName"`
+	FirstName string        `json:"firstName" bson:"firstName"`
+	LastName  string        `json:"lastName" bson:"lastName"`
+	PhotoURL  string        `json:"photoURL" bson:"photoURL"`
+	Role      Role          `json:"role" bson:"role"`
+	// Two-factor authentication.
+	// The secret is kept until TOTPEnabled is true
+	// while the admin is enrolling, and only the hashes
+	// of recovery codes are stored.
+	TOTPSecret         string   `json:"-" bson:"totpSecret,omitempty"`
+	TOTPEnabled        bool     `json:"totpEnabled" bson:"totpEnabled"`
+	RecoveryCodeHashes [][]byte `json:"-" bson:"recoveryCodeHashes,omitempty"`
+	// TOTPLastCounter is the counter of the last TOTP code the admin used,
+	// so that codes can't be used twice.
+	TOTPLastCounter uint64 `json:"-" bson:"totpLastCounter,omitempty"`
 }
 
 // Credentials represents admin sign-in credentials.
@@ -34,24 +48,50 @@ type Credentials struct {
 
 // NewAdmin represents a new admin signing up for an account.
 type NewAdmin struct {
-	Email        string `json:"email"`
+	Email          string `json:"email"`
+	Password       string `json:"password"`
+	PasswordConf   string `json:"passwordConf"`
+	UserName       string `json:"userName"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	InvitationCode string `json:"invitationCode"`
+	// Role is granted by the invitation,
+	// so it is never decoded from clients.
+	Role Role `json:"-"`
+}
+
+// PasswordConfirmation represents a signed-in admin
+// re-confirming their password before a sensitive action.
+type PasswordConfirmation struct {
+	Password string `json:"password"`
+}
+
+// PasswordChange represents a signed-in admin changing their password.
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+	NewPasswordConf string `json:"newPasswordConf"`
+}
+
+// PasswordReset represents an admin resetting a forgotten password
+// using a reset code.
+type PasswordReset struct {
+	ResetCode    string `json:"resetCode"`
 	Password     string `json:"password"`
 	PasswordConf string `json:"passwordConf"`
-	UserName     string `json:"userName"`
-	FirstName    string `json:"firstName"`
-	LastName     string `json:"lastName"`
-	Key          string `json:"key"`
 }
 
 // Updates represents allowed updates to an admin profile.
+// The bson field names must match those of Admin,
+// because Updates is applied to the stored admin through $set.
 type Updates struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+	FirstName string `json:"firstName" bson:"firstName"`
+	LastName  string `json:"lastName" bson:"lastName"`
 }
 
 // Validate validates the new admin and returns an error if
 // any of the validation rules fail, or nil if its valid.
-func (newAdmin *NewAdmin) Validate(signingKey string) error {
+func (newAdmin *NewAdmin) Validate() error {
 
 	// Email field must be a valid email address.
 	_, err := mail.ParseAddress(newAdmin.Email)
@@ -59,14 +99,10 @@ func (newAdmin *NewAdmin) Validate(signingKey string) error {
 		return fmt.Errorf("Error parsing email: %v", err)
 	}
 
-	// Password must be at least 6 characters.
-	if len(newAdmin.Password) < 6 {
-		return fmt.Errorf("Password must be at least 6 characters")
-	}
-
-	// Password and PasswordConf must match.
-	if newAdmin.Password != newAdmin.PasswordConf {
-		return fmt.Errorf("Password must match password confirmation")
+	// Password must satisfy the password rules.
+	err = validatePassword(newAdmin.Password, newAdmin.PasswordConf)
+	if err != nil {
+		return err
 	}
 
 	// UserName must be non-zero length.
@@ -84,9 +120,39 @@ func (newAdmin *NewAdmin) Validate(signingKey string) error {
 		return fmt.Errorf("Last name must be non-zero length")
 	}
 
-	// A valid key must be provided to prevent non-employees sign up.
-	if newAdmin.Key != signingKey {
-		return fmt.Errorf("Key is not valid")
+	// An invitation code must be provided to prevent non-employees sign up.
+	if len(newAdmin.InvitationCode) == 0 {
+		return fmt.Errorf("Invitation code must be non-zero length")
+	}
+
+	return nil
+}
+
+// Validate validates the password change and returns an error if
+// the new password doesn't satisfy the password rules, or nil if its valid.
+func (passwordChange *PasswordChange) Validate() error {
+	return validatePassword(passwordChange.NewPassword, passwordChange.NewPasswordConf)
+}
+
+// Validate validates the password reset and returns an error if
+// the new password doesn't satisfy the password rules, or nil if its valid.
+func (passwordReset *PasswordReset) Validate() error {
+	if len(passwordReset.ResetCode) == 0 {
+		return fmt.Errorf("Reset code must be non-zero length")
+	}
+	return validatePassword(passwordReset.Password, passwordReset.PasswordConf)
+}
+
+// validatePassword validates the password against its confirmation.
+func validatePassword(password string, passwordConf string) error {
+	// Password must be at least 6 characters.
+	if len(password) < 6 {
+		return fmt.Errorf("Password must be at least 6 characters")
+	}
+
+	// Password and PasswordConf must match.
+	if password != passwordConf {
+		return fmt.Errorf("Password must match password confirmation")
 	}
 
 	return nil
@@ -102,13 +168,10 @@ func (newAdmin *NewAdmin) ToAdmin() (*Admin, error) {
 		UserName:  newAdmin.UserName,
 		FirstName: newAdmin.FirstName,
 		LastName:  newAdmin.LastName,
+		Role:      newAdmin.Role,
 	}
 
-	// Trim leading and trailing whitespace from an email address.
-	email := strings.TrimSpace(newAdmin.Email)
-
-	// Force all characters in the email to be lower-case.
-	email = strings.ToLower(email)
+	email := NormalizeEmail(newAdmin.Email)
 
 	// Update Email field.
 	admin.Email = email
@@ -133,6 +196,15 @@ func (newAdmin *NewAdmin) ToAdmin() (*Admin, error) {
 	return admin, nil
 }
 
+// NormalizeEmail returns the email in the form it is stored in.
+func NormalizeEmail(email string) string {
+	// Trim leading and trailing whitespace from an email address.
+	email = strings.TrimSpace(email)
+
+	// Force all characters in the email to be lower-case.
+	return strings.ToLower(email)
+}
+
 // FullName returns the admin's full name, in the form:
 // "<FirstName> <LastName>"
 // If either first or last name is an empty string, no
@@ -156,6 +228,25 @@ func (admin *Admin) SetPassword(password string) error {
 	return nil
 }
 
+// SetBcryptCost sets the bcrypt cost used to hash passwords from now on.
+// Existing hashes with a different cost are upgraded as admins sign in.
+// It should be called before the store is used,
+// for example with bcrypt.MinCost to make tests run faster.
+func SetBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	bcryptCost = cost
+	return nil
+}
+
+// NeedsRehash returns whether the admin's password hash
+// was made with a cost other than the configured one.
+func (admin *Admin) NeedsRehash() bool {
+	cost, err := bcrypt.Cost(admin.PassHash)
+	return err != nil || cost != bcryptCost
+}
+
 // Authenticate compares the plaintext password against the stored hash
 // and returns an error if they don't match, or nil if they do.
 func (admin *Admin) Authenticate(password string) error {
@@ -0,0 +1,61 @@
+package admins
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"net/mail"
+	"time"
+)
+
+// Invitation represents an invitation for a new admin to sign up.
+// An invitation is bound to a single email address,
+// expires after a while, and can be used only once.
+type Invitation struct {
+	Email     string        `json:"email"`
+	Role      Role          `json:"role"`
+	InviterID bson.ObjectId `json:"inviterID,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+}
+
+// NewInvitation represents an existing admin inviting a new admin.
+type NewInvitation struct {
+	Email string `json:"email"`
+	Role  Role   `json:"role"`
+}
+
+// Validate validates the new invitation and returns an error if
+// any of the validation rules fail, or nil if its valid.
+// The inviter can't grant a role more powerful than "inviterRole".
+func (newInvitation *NewInvitation) Validate(inviterRole Role) error {
+	// Email field must be a valid email address.
+	_, err := mail.ParseAddress(newInvitation.Email)
+	if err != nil {
+		return fmt.Errorf("Error parsing email: %v", err)
+	}
+
+	// Role field must be one of the supported roles.
+	if !newInvitation.Role.Valid() {
+		return fmt.Errorf("Role must be one of %s, %s, or %s", RoleOwner, RoleManager, RoleFrontDesk)
+	}
+
+	// Role field must not be more powerful than the inviter's role.
+	if !inviterRole.AtLeast(newInvitation.Role) {
+		return fmt.Errorf("Role %s can't be granted by %s", newInvitation.Role, inviterRole)
+	}
+
+	return nil
+}
+
+// ToInvitation converts the NewInvitation to an Invitation
+// issued by the given inviter, which expires after "duration".
+func (newInvitation *NewInvitation) ToInvitation(inviterID bson.ObjectId, duration time.Duration) *Invitation {
+	now := time.Now()
+	return &Invitation{
+		Email:     NormalizeEmail(newInvitation.Email),
+		Role:      newInvitation.Role,
+		InviterID: inviterID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+}
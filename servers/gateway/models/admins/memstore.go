@@ -0,0 +1,226 @@
+package admins
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"sync"
+)
+
+// MemStore implements Store with a concurrent in-memory map.
+// It is useful for local development and tests where no MongoDB is available.
+type MemStore struct {
+	admins map[bson.ObjectId]*Admin
+	mx     sync.RWMutex
+}
+
+// NewMemStore constructs a new MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		admins: make(map[bson.ObjectId]*Admin),
+	}
+}
+
+// GetByID returns the Admin with the given ID.
+func (store *MemStore) GetByID(id bson.ObjectId) (*Admin, error) {
+	store.mx.RLock()
+	defer store.mx.RUnlock()
+
+	admin, found := store.admins[id]
+	if !found {
+		return nil, ErrAdminNotFound
+	}
+	return copyAdmin(admin), nil
+}
+
+// GetByEmail returns the Admin with the given email.
+func (store *MemStore) GetByEmail(email string) (*Admin, error) {
+	store.mx.RLock()
+	defer store.mx.RUnlock()
+
+	admin := store.find(func(admin *Admin) bool { return admin.Email == email })
+	if admin == nil {
+		return nil, ErrAdminNotFound
+	}
+	return copyAdmin(admin), nil
+}
+
+// GetByUserName returns the Admin with the given Username.
+func (store *MemStore) GetByUserName(username string) (*Admin, error) {
+	store.mx.RLock()
+	defer store.mx.RUnlock()
+
+	admin := store.find(func(admin *Admin) bool { return admin.UserName == username })
+	if admin == nil {
+		return nil, ErrAdminNotFound
+	}
+	return copyAdmin(admin), nil
+}
+
+// CountByRole returns the number of admins with the given role.
+func (store *MemStore) CountByRole(role Role) (int, error) {
+	store.mx.RLock()
+	defer store.mx.RUnlock()
+
+	n := 0
+	for _, admin := range store.admins {
+		if admin.Role == role {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Insert converts the NewAdmin to a Admin, inserts
+// it into the store, and returns it.
+func (store *MemStore) Insert(newAdmin *NewAdmin) (*Admin, error) {
+	admin, err := newAdmin.ToAdmin()
+	if err != nil {
+		return nil, fmt.Errorf("error converting NewAdmin to Admin: %v", err)
+	}
+
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	// Enforce the same uniqueness rules as MongoStore.
+	if store.find(func(a *Admin) bool { return a.Email == admin.Email }) != nil {
+		return nil, ErrDuplicateEmail
+	}
+	if store.find(func(a *Admin) bool { return a.UserName == admin.UserName }) != nil {
+		return nil, ErrDuplicateUserName
+	}
+
+	store.admins[admin.ID] = copyAdmin(admin)
+	return admin, nil
+}
+
+// Update applies update to the given admin ID.
+func (store *MemStore) Update(adminID bson.ObjectId, updates *Updates) error {
+	if updates == nil {
+		return fmt.Errorf("Updates is nil")
+	}
+
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	admin, found := store.admins[adminID]
+	if !found {
+		return ErrAdminNotFound
+	}
+	admin.FirstName = updates.FirstName
+	admin.LastName = updates.LastName
+	return nil
+}
+
+// SetPassHash replaces the password hash of the given admin ID.
+func (store *MemStore) SetPassHash(adminID bson.ObjectId, passHash []byte) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	admin, found := store.admins[adminID]
+	if !found {
+		return ErrAdminNotFound
+	}
+	admin.PassHash = append([]byte(nil), passHash...)
+	return nil
+}
+
+// SetTOTP replaces the two-factor authentication settings of the given admin ID.
+func (store *MemStore) SetTOTP(adminID bson.ObjectId, secret string, enabled bool, recoveryCodeHashes [][]byte) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	admin, found := store.admins[adminID]
+	if !found {
+		return ErrAdminNotFound
+	}
+	admin.TOTPSecret = secret
+	admin.TOTPEnabled = enabled
+	admin.RecoveryCodeHashes = copyHashes(recoveryCodeHashes)
+	return nil
+}
+
+// ConsumeRecoveryCode removes the recovery code hash from the given admin ID,
+// so that each recovery code can be used only once.
+func (store *MemStore) ConsumeRecoveryCode(adminID bson.ObjectId, recoveryCodeHash []byte) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	admin, found := store.admins[adminID]
+	if !found {
+		return ErrRecoveryCodeNotFound
+	}
+	for i, hash := range admin.RecoveryCodeHashes {
+		if bytes.Equal(hash, recoveryCodeHash) {
+			admin.RecoveryCodeHashes = append(admin.RecoveryCodeHashes[:i], admin.RecoveryCodeHashes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRecoveryCodeNotFound
+}
+
+// UseTOTPCounter records the counter of a TOTP code used by the given admin ID,
+// unless the admin has already used a code at least as late.
+func (store *MemStore) UseTOTPCounter(adminID bson.ObjectId, counter uint64) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	admin, found := store.admins[adminID]
+	if !found || counter <= admin.TOTPLastCounter {
+		return ErrTOTPCodeUsed
+	}
+	admin.TOTPLastCounter = counter
+	return nil
+}
+
+// Delete deletes the admin with the given ID.
+// The last owner is never deleted, so that someone can always manage admins.
+func (store *MemStore) Delete(adminID bson.ObjectId) error {
+	store.mx.Lock()
+	defer store.mx.Unlock()
+
+	admin, found := store.admins[adminID]
+	if !found {
+		return ErrAdminNotFound
+	}
+	if admin.Role == RoleOwner {
+		otherOwner := store.find(func(a *Admin) bool { return a.Role == RoleOwner && a.ID != adminID })
+		if otherOwner == nil {
+			return ErrLastOwner
+		}
+	}
+	delete(store.admins, adminID)
+	return nil
+}
+
+// find returns the first admin satisfying "match", or nil if there is none.
+// The caller must hold the lock.
+func (store *MemStore) find(match func(admin *Admin) bool) *Admin {
+	for _, admin := range store.admins {
+		if match(admin) {
+			return admin
+		}
+	}
+	return nil
+}
+
+// copyAdmin returns a copy of the admin,
+// so that callers can't modify the stored one.
+func copyAdmin(admin *Admin) *Admin {
+	c := *admin
+	c.PassHash = append([]byte(nil), admin.PassHash...)
+	c.RecoveryCodeHashes = copyHashes(admin.RecoveryCodeHashes)
+	return &c
+}
+
+// copyHashes returns a deep copy of the hashes.
+func copyHashes(hashes [][]byte) [][]byte {
+	if hashes == nil {
+		return nil
+	}
+	c := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		c[i] = append([]byte(nil), hash...)
+	}
+	return c
+}
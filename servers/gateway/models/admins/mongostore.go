@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
+	"strings"
 )
 
 // MongoStore implements Store for MongoDB.
@@ -13,16 +14,98 @@ type MongoStore struct {
 	colname string // collection name
 }
 
-// NewMongoStore constructs a new MongoStore.
-func NewMongoStore(session *mgo.Session, dbName string, collectionName string) *MongoStore {
+// Names of the unique indexes that guarantee
+// no two admins share the same email or username.
+const emailIndexName = "email_unique"
+const userNameIndexName = "userName_unique"
+
+// legacyUserNameIndexName is the name of the unique index
+// built on the legacy "username" field.
+const legacyUserNameIndexName = "username_unique"
+
+// legacyFieldNames maps the field names mgo derived from
+// untagged Admin fields to the explicit bson field names.
+var legacyFieldNames = map[string]string{
+	"passhash":  "passHash",
+	"username":  "userName",
+	"firstname": "firstName",
+	"lastname":  "lastName",
+	"photourl":  "photoURL",
+}
+
+// NewMongoStore constructs a new MongoStore,
+// migrating existing documents to the current field names
+// and ensuring the unique indexes on email and username exist.
+func NewMongoStore(session *mgo.Session, dbName string, collectionName string) (*MongoStore, error) {
 	if session == nil {
 		panic("nil pointer passed for session")
 	}
-	return &MongoStore{
+	store := &MongoStore{
 		session: session,
 		dbname:  dbName,
 		colname: collectionName,
 	}
+
+	// Migrate before ensuring indexes,
+	// otherwise documents missing the "userName" field
+	// would violate the unique index.
+	err := store.MigrateFieldNames()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.MigrateRoles()
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := []mgo.Index{
+		{Key: []string{"email"}, Unique: true, Name: emailIndexName},
+		{Key: []string{"userName"}, Unique: true, Name: userNameIndexName},
+	}
+	for _, index := range indexes {
+		err = store.session.DB(store.dbname).C(store.colname).EnsureIndex(index)
+		if err != nil {
+			return nil, fmt.Errorf("error ensuring index %s: %v", index.Name, err)
+		}
+	}
+
+	return store, nil
+}
+
+// MigrateFieldNames rewrites existing admin documents
+// stored with legacy field names to use the current field names,
+// and drops the unique index built on the legacy username field.
+// It is safe to run more than once.
+func (store *MongoStore) MigrateFieldNames() error {
+	col := store.session.DB(store.dbname).C(store.colname)
+
+	for legacyName, name := range legacyFieldNames {
+		q := bson.M{legacyName: bson.M{"$exists": true}}
+		_, err := col.UpdateAll(q, bson.M{"$rename": bson.M{legacyName: name}})
+		if err != nil {
+			return fmt.Errorf("error renaming field %s to %s: %v", legacyName, name, err)
+		}
+	}
+
+	// The legacy index may not exist,
+	// so there is nothing to do if dropping it fails.
+	col.DropIndexName(legacyUserNameIndexName)
+
+	return nil
+}
+
+// MigrateRoles grants the owner role to existing admins without a role.
+// Before roles existed every admin had equal power,
+// so this keeps them from losing access.
+// It is safe to run more than once.
+func (store *MongoStore) MigrateRoles() error {
+	q := bson.M{"role": bson.M{"$exists": false}}
+	_, err := store.session.DB(store.dbname).C(store.colname).UpdateAll(q, bson.M{"$set": bson.M{"role": RoleOwner}})
+	if err != nil {
+		return fmt.Errorf("error granting owner role to admins without a role: %v", err)
+	}
+	return nil
 }
 
 // GetByID returns the Admin with the given ID.
@@ -30,6 +113,9 @@ func (store *MongoStore) GetByID(id bson.ObjectId) (*Admin, error) {
 	// Create an empty Admin struct to hold admin data retrieved from MongoDB.
 	admin := &Admin{}
 	err := store.session.DB(store.dbname).C(store.colname).FindId(id).One(admin)
+	if err == mgo.ErrNotFound {
+		return nil, ErrAdminNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving data from MongoDB: %v", err)
 	}
@@ -41,6 +127,9 @@ func (store *MongoStore) GetByEmail(email string) (*Admin, error) {
 	admin := &Admin{}
 	q := bson.M{"email": email}
 	err := store.session.DB(store.dbname).C(store.colname).Find(q).One(admin)
+	if err == mgo.ErrNotFound {
+		return nil, ErrAdminNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving data from MongoDB: %v", err)
 	}
@@ -50,14 +139,26 @@ func (store *MongoStore) GetByEmail(email string) (*Admin, error) {
 // GetByUserName returns the Admin with the given Username.
 func (store *MongoStore) GetByUserName(username string) (*Admin, error) {
 	admin := &Admin{}
-	q := bson.M{"username": username}
+	q := bson.M{"userName": username}
 	err := store.session.DB(store.dbname).C(store.colname).Find(q).One(admin)
+	if err == mgo.ErrNotFound {
+		return nil, ErrAdminNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving data from MongoDB: %v", err)
 	}
 	return admin, nil
 }
 
+// CountByRole returns the number of admins with the given role.
+func (store *MongoStore) CountByRole(role Role) (int, error) {
+	n, err := store.session.DB(store.dbname).C(store.colname).Find(bson.M{"role": role}).Count()
+	if err != nil {
+		return 0, fmt.Errorf("error counting admins: %v", err)
+	}
+	return n, nil
+}
+
 // Insert converts the NewAdmin to a Admin, inserts
 // it into the database, and returns it.
 func (store *MongoStore) Insert(newAdmin *NewAdmin) (*Admin, error) {
@@ -67,6 +168,16 @@ func (store *MongoStore) Insert(newAdmin *NewAdmin) (*Admin, error) {
 	}
 
 	err = store.session.DB(store.dbname).C(store.colname).Insert(admin)
+	// Uniqueness is enforced atomically by the unique indexes,
+	// so translate duplicate key errors into typed errors.
+	if mgo.IsDup(err) {
+		if strings.Contains(err.Error(), emailIndexName) {
+			return nil, ErrDuplicateEmail
+		}
+		if strings.Contains(err.Error(), userNameIndexName) {
+			return nil, ErrDuplicateUserName
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error inserting data into MongoDB: %v", err)
 	}
@@ -87,6 +198,85 @@ func (store *MongoStore) Update(adminID bson.ObjectId, updates *Updates) error {
 	admin := &Admin{}
 
 	_, err := store.session.DB(store.dbname).C(store.colname).FindId(adminID).Apply(change, admin)
+	if err == mgo.ErrNotFound {
+		return ErrAdminNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error updating MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+// SetPassHash replaces the password hash of the given admin ID.
+func (store *MongoStore) SetPassHash(adminID bson.ObjectId, passHash []byte) error {
+	update := bson.M{"$set": bson.M{"passHash": passHash}}
+	err := store.session.DB(store.dbname).C(store.colname).UpdateId(adminID, update)
+	if err == mgo.ErrNotFound {
+		return ErrAdminNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error updating MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+// SetTOTP replaces the two-factor authentication settings of the given admin ID.
+func (store *MongoStore) SetTOTP(adminID bson.ObjectId, secret string, enabled bool, recoveryCodeHashes [][]byte) error {
+	update := bson.M{
+		"$set": bson.M{
+			"totpSecret":         secret,
+			"totpEnabled":        enabled,
+			"recoveryCodeHashes": recoveryCodeHashes,
+		},
+	}
+	err := store.session.DB(store.dbname).C(store.colname).UpdateId(adminID, update)
+	if err == mgo.ErrNotFound {
+		return ErrAdminNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error updating MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+// ConsumeRecoveryCode removes the recovery code hash from the given admin ID,
+// so that each recovery code can be used only once.
+func (store *MongoStore) ConsumeRecoveryCode(adminID bson.ObjectId, recoveryCodeHash []byte) error {
+	// Matching on the hash makes the removal atomic,
+	// so that two concurrent sign-ins can't both use the same code.
+	query := bson.M{"_id": adminID, "recoveryCodeHashes": recoveryCodeHash}
+	update := bson.M{"$pull": bson.M{"recoveryCodeHashes": recoveryCodeHash}}
+	err := store.session.DB(store.dbname).C(store.colname).Update(query, update)
+	if err == mgo.ErrNotFound {
+		return ErrRecoveryCodeNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error updating MongoDB: %v", err)
+	}
+
+	return nil
+}
+
+// UseTOTPCounter records the counter of a TOTP code used by the given admin ID,
+// unless the admin has already used a code at least as late.
+func (store *MongoStore) UseTOTPCounter(adminID bson.ObjectId, counter uint64) error {
+	// Matching on the last counter makes the update atomic,
+	// so that two concurrent sign-ins can't both use the same code.
+	query := bson.M{
+		"_id": adminID,
+		"$or": []bson.M{
+			{"totpLastCounter": bson.M{"$lt": counter}},
+			{"totpLastCounter": bson.M{"$exists": false}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"totpLastCounter": counter}}
+	err := store.session.DB(store.dbname).C(store.colname).Update(query, update)
+	if err == mgo.ErrNotFound {
+		return ErrTOTPCodeUsed
+	}
 	if err != nil {
 		return fmt.Errorf("error updating MongoDB: %v", err)
 	}
@@ -95,11 +285,44 @@ func (store *MongoStore) Update(adminID bson.ObjectId, updates *Updates) error {
 }
 
 // Delete deletes the admin with the given ID.
+// The last owner is never deleted, so that someone can always manage admins.
 func (store *MongoStore) Delete(adminID bson.ObjectId) error {
-	err := store.session.DB(store.dbname).C(store.colname).RemoveId(adminID)
+	col := store.session.DB(store.dbname).C(store.colname)
+	admin := &Admin{}
+	err := col.FindId(adminID).One(admin)
+	if err == mgo.ErrNotFound {
+		return ErrAdminNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error finding admin: %v", err)
+	}
+
+	err = col.RemoveId(adminID)
+	if err == mgo.ErrNotFound {
+		return ErrAdminNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("error deleting data: %v", err)
 	}
+	if admin.Role != RoleOwner {
+		return nil
+	}
+
+	// MongoDB can't remove a document on the condition of other documents,
+	// so owners are removed first and restored if no other owner is left.
+	// Owners deleted concurrently each see the others' removals,
+	// so at least one of them is always restored.
+	owners, err := col.Find(bson.M{"role": RoleOwner}).Count()
+	if err != nil {
+		return fmt.Errorf("error counting owners: %v", err)
+	}
+	if owners == 0 {
+		err = col.Insert(admin)
+		if err != nil {
+			return fmt.Errorf("error restoring last owner: %v", err)
+		}
+		return ErrLastOwner
+	}
 
 	return nil
 }
@@ -0,0 +1,32 @@
+package admins
+
+// Role represents what an admin is allowed to do.
+type Role string
+
+// Supported roles, from the most to the least powerful.
+const (
+	// RoleOwner can do everything, including managing other admins.
+	RoleOwner Role = "owner"
+	// RoleManager can manage offices and invite new admins.
+	RoleManager Role = "manager"
+	// RoleFrontDesk can check visitors in.
+	RoleFrontDesk Role = "front-desk"
+)
+
+// roleRanks ranks roles by how powerful they are.
+var roleRanks = map[Role]int{
+	RoleOwner:     3,
+	RoleManager:   2,
+	RoleFrontDesk: 1,
+}
+
+// Valid returns whether the role is one of the supported roles.
+func (role Role) Valid() bool {
+	_, found := roleRanks[role]
+	return found
+}
+
+// AtLeast returns whether the role is at least as powerful as "other".
+func (role Role) AtLeast(other Role) bool {
+	return role.Valid() && roleRanks[role] >= roleRanks[other]
+}
@@ -8,6 +8,26 @@ import (
 // ErrAdminNotFound is returned when the admin can't be found.
 var ErrAdminNotFound = errors.New("Admin not found")
 
+// ErrDuplicateEmail is returned from Insert when
+// an admin with the same email already exists.
+var ErrDuplicateEmail = errors.New("Admin with the same email already exists")
+
+// ErrDuplicateUserName is returned from Insert when
+// an admin with the same username already exists.
+var ErrDuplicateUserName = errors.New("Admin with the same username already exists")
+
+// ErrLastOwner is returned from Delete when
+// deleting the admin would leave no owner.
+var ErrLastOwner = errors.New("The last owner can't be deleted")
+
+// ErrRecoveryCodeNotFound is returned from ConsumeRecoveryCode when
+// the admin has no unused recovery code with the given hash.
+var ErrRecoveryCodeNotFound = errors.New("Recovery code not found")
+
+// ErrTOTPCodeUsed is returned from UseTOTPCounter when
+// the admin has already used a code at least as late.
+var ErrTOTPCodeUsed = errors.New("Two-factor authentication code already used")
+
 // Store represents a store for Users.
 type Store interface {
 	// GetByID returns the Admin with the given ID.
@@ -19,6 +39,9 @@ type Store interface {
 	// GetByUserName returns the Admin with the given Username.
 	GetByUserName(username string) (*Admin, error)
 
+	// CountByRole returns the number of admins with the given role.
+	CountByRole(role Role) (int, error)
+
 	// Insert converts the NewUser to a Admin, inserts
 	// it into the database, and returns it.
 	Insert(newAdmin *NewAdmin) (*Admin, error)
@@ -26,6 +49,22 @@ type Store interface {
 	// Update applies UserUpdates to the given admin ID.
 	Update(adminID bson.ObjectId, updates *Updates) error
 
+	// SetPassHash replaces the password hash of the given admin ID.
+	SetPassHash(adminID bson.ObjectId, passHash []byte) error
+
+	// SetTOTP replaces the two-factor authentication settings of the given admin ID.
+	SetTOTP(adminID bson.ObjectId, secret string, enabled bool, recoveryCodeHashes [][]byte) error
+
+	// ConsumeRecoveryCode removes the recovery code hash from the given admin ID,
+	// so that each recovery code can be used only once.
+	ConsumeRecoveryCode(adminID bson.ObjectId, recoveryCodeHash []byte) error
+
+	// UseTOTPCounter records the counter of a TOTP code used by the given admin ID,
+	// unless the admin has already used a code at least as late.
+	UseTOTPCounter(adminID bson.ObjectId, counter uint64) error
+
 	// Delete deletes the admin with the given ID.
+	// The last owner is never deleted, even when owners are deleted
+	// concurrently, so that someone can always manage admins.
 	Delete(adminID bson.ObjectId) error
 }
@@ -0,0 +1,91 @@
+package admins
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zicodeng/visitorex/servers/gateway/totp"
+)
+
+// recoveryCodeCount is the number of recovery codes
+// an admin gets when enabling two-factor authentication.
+const recoveryCodeCount = 10
+
+// recoveryCodeLength is the number of random bytes in a recovery code.
+const recoveryCodeLength = 5
+
+// TOTPConfirmation represents an admin confirming
+// their two-factor authentication enrollment with a code
+// from their authenticator app, and re-confirming their password.
+type TOTPConfirmation struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// SecondFactor represents an admin completing a pending sign-in
+// with either a code from their authenticator app or a recovery code.
+type SecondFactor struct {
+	PendingToken string `json:"pendingToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// Validate validates the second factor and returns an error if
+// any of the validation rules fail, or nil if its valid.
+func (secondFactor *SecondFactor) Validate() error {
+	if len(secondFactor.PendingToken) == 0 {
+		return fmt.Errorf("Pending token must be non-zero length")
+	}
+
+	// Exactly one of Code and RecoveryCode must be provided.
+	if (len(secondFactor.Code) == 0) == (len(secondFactor.RecoveryCode) == 0) {
+		return fmt.Errorf("Either code or recovery code must be provided")
+	}
+
+	return nil
+}
+
+// AuthenticateTOTP checks the code against the admin's TOTP secret
+// and returns an error if it isn't valid, or the counter of the code if it is.
+// Codes no later than the last one the admin used are rejected,
+// and the returned counter must be recorded with Store.UseTOTPCounter.
+func (admin *Admin) AuthenticateTOTP(code string) (uint64, error) {
+	if len(admin.TOTPSecret) == 0 {
+		return 0, fmt.Errorf("invalid two-factor authentication code")
+	}
+	counter, valid := totp.Validate(admin.TOTPSecret, code, time.Now())
+	if !valid || counter <= admin.TOTPLastCounter {
+		return 0, fmt.Errorf("invalid two-factor authentication code")
+	}
+	return counter, nil
+}
+
+// NewRecoveryCodes generates a new set of recovery codes,
+// and returns them along with the hashes to store in place of them.
+func NewRecoveryCodes() ([]string, [][]byte, error) {
+	recoveryCodes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range recoveryCodes {
+		b := make([]byte, recoveryCodeLength)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+		recoveryCodes[i] = hex.EncodeToString(b)
+		hashes[i] = HashRecoveryCode(recoveryCodes[i])
+	}
+	return recoveryCodes, hashes, nil
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored as.
+// Recovery codes are random, so a plain SHA-256 hash is enough.
+func HashRecoveryCode(recoveryCode string) []byte {
+	recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+	recoveryCode = strings.Replace(recoveryCode, "-", "", -1)
+	h := sha256.Sum256([]byte(recoveryCode))
+	return h[:]
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Lockout represents a key that is temporarily locked out
+// after too many failed attempts.
+type Lockout struct {
+	Key       string    `json:"key"`
+	Failures  int64     `json:"failures"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Policy configures how the attempts of a kind of key are limited.
+// Once a key has failed Threshold attempts within Window,
+// it is locked out for BaseDelay, and the attempts start over.
+// Every further lockout doubles the delay up to MaxDelay,
+// until a whole Window passes without one.
+type Policy struct {
+	// Number of failed attempts before a key is locked out.
+	Threshold int64
+	// Lockout duration the first time Threshold is reached.
+	BaseDelay time.Duration
+	// Longest lockout duration.
+	MaxDelay time.Duration
+	// How long attempts are counted, starting from the first one.
+	Window time.Duration
+}
+
+// Validate validates the policy and returns an error if
+// any of the validation rules fail, or nil if its valid.
+func (policy *Policy) Validate() error {
+	if policy.Threshold <= 0 {
+		return fmt.Errorf("Threshold must be positive")
+	}
+	if policy.BaseDelay <= 0 {
+		return fmt.Errorf("Base delay must be positive")
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		return fmt.Errorf("Max delay must be at least the base delay")
+	}
+	if policy.Window <= 0 {
+		return fmt.Errorf("Window must be positive")
+	}
+	return nil
+}
+
+// delay returns how long a key is locked out the "strikes"-th time in a row.
+func (policy *Policy) delay(strikes int64) time.Duration {
+	delay := policy.BaseDelay
+	for i := int64(1); i < strikes && delay < policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	return delay
+}
+
+// Policies maps key prefixes, such as "email:" or "ip:",
+// to the policy of the keys beginning with them.
+type Policies map[string]*Policy
+
+// Validate validates every policy and returns an error if
+// any of them is invalid, or nil if they are all valid.
+func (policies Policies) Validate() error {
+	if len(policies) == 0 {
+		return fmt.Errorf("At least one policy is required")
+	}
+	for prefix, policy := range policies {
+		if policy == nil {
+			return fmt.Errorf("Policy for %q is nil", prefix)
+		}
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("Policy for %q: %v", prefix, err)
+		}
+	}
+	return nil
+}
+
+// policyFor returns the policy of the longest prefix the key begins with.
+func (policies Policies) policyFor(key string) (*Policy, error) {
+	var policy *Policy
+	longest := -1
+	for prefix, p := range policies {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			policy = p
+			longest = len(prefix)
+		}
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("no rate limit policy for %s", key)
+	}
+	return policy, nil
+}
+
+// Limiter tracks failed attempts per key, such as per email or per client IP,
+// and temporarily locks out keys with too many failed attempts.
+// This is an abstract interface that can be implemented
+// against several different types of data stores.
+type Limiter interface {
+	// Attempt atomically counts an attempt for each of the keys
+	// before the attempt is verified. It returns how long until
+	// all of the keys may try again if any of them is locked out,
+	// in which case the attempt must be refused, or zero otherwise.
+	// A key with more attempts than its threshold, which only happens
+	// when attempts are made concurrently, is locked out right away,
+	// so that concurrent guesses can't get past the limit.
+	Attempt(keys ...string) (time.Duration, error)
+
+	// Fail locks out the keys of a failed attempt
+	// once they have failed their threshold of attempts.
+	Fail(keys ...string) error
+
+	// Forgive takes back one attempt of the key,
+	// for attempts that turned out to be legitimate.
+	Forgive(key string) error
+
+	// Reset clears the attempts and lockouts of the key.
+	Reset(key string) error
+
+	// Lockouts returns all keys that are currently locked out.
+	Lockouts() ([]*Lockout, error)
+}
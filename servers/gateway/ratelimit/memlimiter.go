@@ -0,0 +1,228 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memEntry is the state of a key kept by MemLimiter.
+type memEntry struct {
+	// Attempts counted since windowEnd - Window.
+	failures  int64
+	windowEnd time.Time
+	// Failures that led to the current lockout.
+	lockedFailures int64
+	lockedUntil    time.Time
+	// Lockouts in a row, forgotten after strikesEnd.
+	strikes    int64
+	strikesEnd time.Time
+}
+
+// expire forgets the parts of the entry that have expired at "now".
+func (entry *memEntry) expire(now time.Time) {
+	if !now.Before(entry.windowEnd) {
+		entry.failures = 0
+	}
+	if !now.Before(entry.strikesEnd) {
+		entry.strikes = 0
+	}
+}
+
+// empty returns whether nothing is left of the entry at "now".
+func (entry *memEntry) empty(now time.Time) bool {
+	entry.expire(now)
+	return entry.failures == 0 && entry.strikes == 0 && !now.Before(entry.lockedUntil)
+}
+
+// MemLimiter represents a ratelimit.Limiter backed by a concurrent in-memory map.
+// It is useful for local development and tests where no Redis server is available.
+type MemLimiter struct {
+	entries map[string]*memEntry
+	mx      sync.Mutex
+	// Policy of each kind of key.
+	Policies Policies
+}
+
+// NewMemLimiter constructs a new MemLimiter.
+// Expired entries are evicted by a background goroutine
+// every "sweepInterval".
+func NewMemLimiter(policies Policies, sweepInterval time.Duration) *MemLimiter {
+	if err := policies.Validate(); err != nil {
+		panic(fmt.Sprintf("Invalid rate limit policies: %v", err))
+	}
+
+	if sweepInterval <= 0 {
+		panic("Sweep interval must be positive")
+	}
+
+	memLimiter := &MemLimiter{
+		entries:  make(map[string]*memEntry),
+		Policies: policies,
+	}
+	go memLimiter.sweep(sweepInterval)
+	return memLimiter
+}
+
+// Limiter implementation
+
+// Attempt atomically counts an attempt for each of the keys
+// before the attempt is verified. It returns how long until
+// all of the keys may try again if any of them is locked out,
+// in which case the attempt must be refused, or zero otherwise.
+// A key with more attempts than its threshold, which only happens
+// when attempts are made concurrently, is locked out right away,
+// so that concurrent guesses can't get past the limit.
+func (ml *MemLimiter) Attempt(keys ...string) (time.Duration, error) {
+	policies, err := ml.policiesFor(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	ml.mx.Lock()
+	defer ml.mx.Unlock()
+
+	var longest time.Duration
+	for _, key := range keys {
+		if entry, found := ml.entries[key]; found && entry.lockedUntil.Sub(now) > longest {
+			longest = entry.lockedUntil.Sub(now)
+		}
+	}
+	if longest > 0 {
+		return longest, nil
+	}
+
+	for i, key := range keys {
+		entry := ml.entry(key, now)
+		if entry.failures == 0 {
+			entry.windowEnd = now.Add(policies[i].Window)
+		}
+		entry.failures++
+		if entry.failures > policies[i].Threshold {
+			if delay := ml.lockOut(entry, policies[i], now); delay > longest {
+				longest = delay
+			}
+		}
+	}
+	return longest, nil
+}
+
+// Fail locks out the keys of a failed attempt
+// once they have failed their threshold of attempts.
+func (ml *MemLimiter) Fail(keys ...string) error {
+	policies, err := ml.policiesFor(keys)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	ml.mx.Lock()
+	defer ml.mx.Unlock()
+
+	for i, key := range keys {
+		entry := ml.entry(key, now)
+		if entry.failures >= policies[i].Threshold {
+			ml.lockOut(entry, policies[i], now)
+		}
+	}
+	return nil
+}
+
+// Forgive takes back one attempt of the key,
+// for attempts that turned out to be legitimate.
+func (ml *MemLimiter) Forgive(key string) error {
+	now := time.Now()
+	ml.mx.Lock()
+	defer ml.mx.Unlock()
+
+	if entry, found := ml.entries[key]; found {
+		entry.expire(now)
+		if entry.failures > 0 {
+			entry.failures--
+		}
+	}
+	return nil
+}
+
+// Reset clears the attempts and lockouts of the key.
+func (ml *MemLimiter) Reset(key string) error {
+	ml.mx.Lock()
+	delete(ml.entries, key)
+	ml.mx.Unlock()
+	return nil
+}
+
+// Lockouts returns all keys that are currently locked out.
+func (ml *MemLimiter) Lockouts() ([]*Lockout, error) {
+	now := time.Now()
+	ml.mx.Lock()
+	defer ml.mx.Unlock()
+
+	lockouts := []*Lockout{}
+	for key, entry := range ml.entries {
+		if now.Before(entry.lockedUntil) {
+			lockouts = append(lockouts, &Lockout{
+				Key:       key,
+				Failures:  entry.lockedFailures,
+				ExpiresAt: entry.lockedUntil,
+			})
+		}
+	}
+	return lockouts, nil
+}
+
+// policiesFor returns the policy of each of the keys.
+func (ml *MemLimiter) policiesFor(keys []string) ([]*Policy, error) {
+	policies := make([]*Policy, len(keys))
+	for i, key := range keys {
+		policy, err := ml.Policies.policyFor(key)
+		if err != nil {
+			return nil, err
+		}
+		policies[i] = policy
+	}
+	return policies, nil
+}
+
+// entry returns the entry of the key at "now", creating it if needed.
+// The caller must hold the lock.
+func (ml *MemLimiter) entry(key string, now time.Time) *memEntry {
+	entry, found := ml.entries[key]
+	if !found {
+		entry = &memEntry{}
+		ml.entries[key] = entry
+	}
+	entry.expire(now)
+	return entry
+}
+
+// lockOut locks out the entry for the policy's delay,
+// doubled for every lockout since its strikes were last forgotten,
+// starts its attempts over, and returns for how long it is locked out.
+// The caller must hold the lock.
+func (ml *MemLimiter) lockOut(entry *memEntry, policy *Policy, now time.Time) time.Duration {
+	entry.strikes++
+	entry.strikesEnd = now.Add(policy.Window)
+	delay := policy.delay(entry.strikes)
+	entry.lockedFailures = entry.failures
+	entry.lockedUntil = now.Add(delay)
+	entry.failures = 0
+	return delay
+}
+
+// sweep periodically evicts entries that have nothing left to remember.
+func (ml *MemLimiter) sweep(sweepInterval time.Duration) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		ml.mx.Lock()
+		for key, entry := range ml.entries {
+			if entry.empty(now) {
+				delete(ml.entries, key)
+			}
+		}
+		ml.mx.Unlock()
+	}
+}
@@ -0,0 +1,220 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const failuresPrefix = "loginFailures:"
+const lockoutPrefix = "loginLockout:"
+const strikesPrefix = "loginStrikes:"
+
+// lockOutFunction is shared by the scripts below.
+// It locks out a key for its policy's delay, doubled for every lockout
+// since its strikes were last forgotten, and starts its attempts over.
+const lockOutFunction = `
+local function lockOut(failures, lockout, strikes, window, baseDelay, maxDelay)
+	local n = redis.call("INCR", strikes)
+	redis.call("PEXPIRE", strikes, window)
+	local delay = baseDelay
+	for i = 2, n do
+		if delay >= maxDelay then
+			break
+		end
+		delay = delay * 2
+	end
+	if delay > maxDelay then
+		delay = maxDelay
+	end
+	local count = redis.call("GET", failures) or "0"
+	redis.call("SET", lockout, count, "PX", delay)
+	redis.call("DEL", failures)
+	return delay
+end
+`
+
+// attemptScript counts an attempt for every key, unless any of them is locked out.
+// KEYS holds the failures, lockout and strikes keys of each key in turn,
+// and ARGV holds the threshold, window, base delay and max delay
+// of each key in turn, with durations in milliseconds.
+// It returns the longest remaining lockout in milliseconds.
+var attemptScript = redis.NewScript(lockOutFunction + `
+local longest = 0
+for i = 1, #KEYS, 3 do
+	local ttl = redis.call("PTTL", KEYS[i + 1])
+	if ttl > longest then
+		longest = ttl
+	end
+end
+if longest > 0 then
+	return longest
+end
+for i = 1, #KEYS, 3 do
+	local j = (i - 1) / 3 * 4
+	local n = redis.call("INCR", KEYS[i])
+	if n == 1 then
+		redis.call("PEXPIRE", KEYS[i], ARGV[j + 2])
+	end
+	if n > tonumber(ARGV[j + 1]) then
+		local delay = lockOut(KEYS[i], KEYS[i + 1], KEYS[i + 2], ARGV[j + 2], tonumber(ARGV[j + 3]), tonumber(ARGV[j + 4]))
+		if delay > longest then
+			longest = delay
+		end
+	end
+end
+return longest
+`)
+
+// failScript locks out every key that has failed its threshold of attempts.
+// KEYS and ARGV are laid out as for attemptScript.
+var failScript = redis.NewScript(lockOutFunction + `
+for i = 1, #KEYS, 3 do
+	local j = (i - 1) / 3 * 4
+	local n = tonumber(redis.call("GET", KEYS[i]) or "0")
+	if n >= tonumber(ARGV[j + 1]) then
+		lockOut(KEYS[i], KEYS[i + 1], KEYS[i + 2], ARGV[j + 2], tonumber(ARGV[j + 3]), tonumber(ARGV[j + 4]))
+	end
+end
+return 0
+`)
+
+// forgiveScript takes back one attempt, if there is any.
+var forgiveScript = redis.NewScript(`
+local attempts = tonumber(redis.call("GET", KEYS[1]) or "0")
+if attempts > 0 then
+	redis.call("DECR", KEYS[1])
+end
+return attempts
+`)
+
+// RedisLimiter represents a ratelimit.Limiter backed by redis.
+type RedisLimiter struct {
+	// Redis client used to talk to Redis server.
+	Client *redis.Client
+	// Policy of each kind of key.
+	Policies Policies
+}
+
+// NewRedisLimiter constructs a new RedisLimiter.
+func NewRedisLimiter(client *redis.Client, policies Policies) *RedisLimiter {
+	if client == nil {
+		panic("Nil Redis client")
+	}
+
+	if err := policies.Validate(); err != nil {
+		panic(fmt.Sprintf("Invalid rate limit policies: %v", err))
+	}
+
+	return &RedisLimiter{
+		Client:   client,
+		Policies: policies,
+	}
+}
+
+// Limiter implementation
+
+// Attempt atomically counts an attempt for each of the keys
+// before the attempt is verified. It returns how long until
+// all of the keys may try again if any of them is locked out,
+// in which case the attempt must be refused, or zero otherwise.
+// A key with more attempts than its threshold, which only happens
+// when attempts are made concurrently, is locked out right away,
+// so that concurrent guesses can't get past the limit.
+func (rl *RedisLimiter) Attempt(keys ...string) (time.Duration, error) {
+	redisKeys, args, err := rl.scriptArgs(keys)
+	if err != nil {
+		return 0, err
+	}
+	longest, err := attemptScript.Run(rl.Client, redisKeys, args...).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("error recording attempt: %v", err)
+	}
+	return time.Duration(longest) * time.Millisecond, nil
+}
+
+// Fail locks out the keys of a failed attempt
+// once they have failed their threshold of attempts.
+func (rl *RedisLimiter) Fail(keys ...string) error {
+	redisKeys, args, err := rl.scriptArgs(keys)
+	if err != nil {
+		return err
+	}
+	err = failScript.Run(rl.Client, redisKeys, args...).Err()
+	if err != nil {
+		return fmt.Errorf("error recording failed attempt: %v", err)
+	}
+	return nil
+}
+
+// Forgive takes back one attempt of the key,
+// for attempts that turned out to be legitimate.
+func (rl *RedisLimiter) Forgive(key string) error {
+	err := forgiveScript.Run(rl.Client, []string{failuresPrefix + key}).Err()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("error forgiving attempt of %s: %v", key, err)
+	}
+	return nil
+}
+
+// Reset clears the attempts and lockouts of the key.
+func (rl *RedisLimiter) Reset(key string) error {
+	err := rl.Client.Del(failuresPrefix+key, lockoutPrefix+key, strikesPrefix+key).Err()
+	if err != nil {
+		return fmt.Errorf("error resetting %s: %v", key, err)
+	}
+	return nil
+}
+
+// Lockouts returns all keys that are currently locked out.
+func (rl *RedisLimiter) Lockouts() ([]*Lockout, error) {
+	lockouts := []*Lockout{}
+	iter := rl.Client.Scan(0, lockoutPrefix+"*", 100).Iterator()
+	for iter.Next() {
+		redisKey := iter.Val()
+
+		pipe := rl.Client.Pipeline()
+		get := pipe.Get(redisKey)
+		ttl := pipe.PTTL(redisKey)
+		pipe.Exec()
+		pipe.Close()
+
+		// The lockout might have expired since it was scanned.
+		failures, err := get.Int64()
+		if err != nil || ttl.Val() <= 0 {
+			continue
+		}
+		lockouts = append(lockouts, &Lockout{
+			Key:       strings.TrimPrefix(redisKey, lockoutPrefix),
+			Failures:  failures,
+			ExpiresAt: time.Now().Add(ttl.Val()),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning lockouts: %v", err)
+	}
+	return lockouts, nil
+}
+
+// scriptArgs returns the KEYS and ARGV of attemptScript and failScript
+// for the given keys.
+func (rl *RedisLimiter) scriptArgs(keys []string) ([]string, []interface{}, error) {
+	redisKeys := make([]string, 0, len(keys)*3)
+	args := make([]interface{}, 0, len(keys)*4)
+	for _, key := range keys {
+		policy, err := rl.Policies.policyFor(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		redisKeys = append(redisKeys, failuresPrefix+key, lockoutPrefix+key, strikesPrefix+key)
+		args = append(args,
+			policy.Threshold,
+			int64(policy.Window/time.Millisecond),
+			int64(policy.BaseDelay/time.Millisecond),
+			int64(policy.MaxDelay/time.Millisecond),
+		)
+	}
+	return redisKeys, args, nil
+}
@@ -16,6 +16,25 @@ export DB_NAME=app
 export APP_NETWORK=appnet
 export SESSION_KEY=seeitrun
 
+# The key microservices sign their announcements with is a deployment secret,
+# so it is never kept in the repository. It must be shared with
+# every microservice and differ from SESSION_KEY.
+if [ -z "$SERVICE_KEY" ]; then
+    echo 'Please set SERVICE_KEY environment variable'
+    exit 1
+fi
+if [ "$SERVICE_KEY" = "$SESSION_KEY" ]; then
+    echo 'SERVICE_KEY must be different from SESSION_KEY'
+    exit 1
+fi
+
+# Reset codes and invitation codes are mailed by logging them,
+# so MAIL_LOG must point to a protected file on the server.
+if [ -z "$MAIL_LOG" ]; then
+    echo 'Please set MAIL_LOG environment variable'
+    exit 1
+fi
+
 export TLS_CERT=/etc/letsencrypt/live/visitorex-api.zicodeng.me/fullchain.pem
 export TLS_KEY=/etc/letsencrypt/live/visitorex-api.zicodeng.me/privkey.pem
 
@@ -88,6 +107,9 @@ docker run \
 -e TLS_CERT=$TLS_CERT \
 -e TLS_KEY=$TLS_KEY \
 -e SESSION_KEY=$SESSION_KEY \
+-e SERVICE_KEY \
+-e MAIL_LOG=$MAIL_LOG \
+-v $(dirname $MAIL_LOG):$(dirname $MAIL_LOG) \
 -e SERVER_ADDR=$SERVER_ADDR \
 -e REDIS_ADDR=$REDIS_ADDR \
 -e MONGO_ADDR=$MONGO_ADDR \
@@ -0,0 +1,42 @@
+package sessions
+
+import (
+	"errors"
+)
+
+// ErrEmptySigningKey is returned when a zero-length signing key is passed to NewKeyring().
+var ErrEmptySigningKey = errors.New("signing key cannot be zero")
+
+// Keyring holds the HMAC signing keys for session tokens.
+// New session tokens are always signed with the current key,
+// while session tokens signed with any of the previous keys
+// are still valid. This allows rotating the signing key
+// without ending every session.
+type Keyring struct {
+	// keys[0] is the current key,
+	// followed by the previous keys from newest to oldest.
+	keys []string
+}
+
+// NewKeyring constructs a new Keyring that signs with "currentKey",
+// and validates against "currentKey" plus "previousKeys".
+func NewKeyring(currentKey string, previousKeys ...string) (*Keyring, error) {
+	keys := append([]string{currentKey}, previousKeys...)
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, ErrEmptySigningKey
+		}
+	}
+	return &Keyring{keys}, nil
+}
+
+// Current returns the key new session tokens are signed with.
+func (keyring *Keyring) Current() string {
+	return keyring.keys[0]
+}
+
+// Keys returns all keys session tokens are validated against,
+// starting with the current key.
+func (keyring *Keyring) Keys() []string {
+	return keyring.keys
+}
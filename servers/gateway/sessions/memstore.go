@@ -0,0 +1,219 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memEntry is a session state entry kept by MemStore.
+type memEntry struct {
+	// JSON-encoded session state,
+	// so that callers never share memory with the store.
+	state []byte
+	// Time after which this entry is considered expired.
+	expiry time.Time
+	// Time the session began,
+	// used to enforce the absolute maximum age.
+	beginTime time.Time
+}
+
+// expired returns whether the entry has expired at "now",
+// either because it hasn't been used for a while,
+// or because it is older than "maxAge".
+func (entry *memEntry) expired(now time.Time, maxAge time.Duration) bool {
+	return now.After(entry.expiry) || now.Sub(entry.beginTime) > maxAge
+}
+
+// MemStore represents a sessions.Store backed by a concurrent in-memory map.
+// It is useful for local development and tests where no Redis server is available.
+type MemStore struct {
+	entries map[SessionToken]*memEntry
+	// The key of the indexes map is the owner of the session tokens.
+	indexes map[string]map[SessionToken]bool
+	mx      sync.RWMutex
+	// Used for entry expiry time.
+	SessionDuration time.Duration
+	// Absolute maximum age of a session,
+	// no matter how recently it has been used.
+	MaxAge time.Duration
+}
+
+// NewMemStore constructs a new MemStore.
+// Expired entries are evicted by a background goroutine
+// every "sweepInterval".
+func NewMemStore(sessionDuration time.Duration, maxAge time.Duration, sweepInterval time.Duration) *MemStore {
+	if maxAge <= 0 {
+		panic("Max age must be positive")
+	}
+
+	if sweepInterval <= 0 {
+		panic("Sweep interval must be positive")
+	}
+
+	memStore := &MemStore{
+		entries:         make(map[SessionToken]*memEntry),
+		indexes:         make(map[string]map[SessionToken]bool),
+		SessionDuration: sessionDuration,
+		MaxAge:          maxAge,
+	}
+	go memStore.sweep(sweepInterval)
+	return memStore
+}
+
+// Store implementation
+
+// Save saves the provided "sessionState" and associated sessionToken to the store.
+// The "sessionState" parameter is typically a pointer to a struct containing
+// all the data you want to associated with the given sessionToken.
+func (ms *MemStore) Save(sessionToken SessionToken, sessionState interface{}) error {
+	j, err := json.Marshal(sessionState)
+	if err != nil {
+		return fmt.Errorf("error marshalling struct to JSON: %v", err)
+	}
+
+	now := time.Now()
+	ms.mx.Lock()
+	// Keep the begin time of an existing session,
+	// so that saving it again doesn't extend its MaxAge.
+	beginTime := now
+	if entry, found := ms.entries[sessionToken]; found && !entry.expired(now, ms.MaxAge) {
+		beginTime = entry.beginTime
+	}
+	ms.entries[sessionToken] = &memEntry{
+		state:     j,
+		expiry:    now.Add(ms.SessionDuration),
+		beginTime: beginTime,
+	}
+	ms.mx.Unlock()
+
+	return nil
+}
+
+// Get populates "sessionState" with the data previously saved
+// for the given sessionToken.
+func (ms *MemStore) Get(sessionToken SessionToken, sessionState interface{}) error {
+	// Take the write lock, because the expiry time
+	// is reset on every read just like RedisStore does.
+	ms.mx.Lock()
+	entry, found := ms.entries[sessionToken]
+	if !found || entry.expired(time.Now(), ms.MaxAge) {
+		delete(ms.entries, sessionToken)
+		ms.mx.Unlock()
+		return ErrStateNotFound
+	}
+	entry.expiry = time.Now().Add(ms.SessionDuration)
+	state := entry.state
+	ms.mx.Unlock()
+
+	err := json.Unmarshal(state, sessionState)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON to struct: %v", err)
+	}
+
+	return nil
+}
+
+// Peek populates "sessionState" with the data previously saved
+// for the given sessionToken just like Get does,
+// but doesn't reset the expiry time of the session.
+func (ms *MemStore) Peek(sessionToken SessionToken, sessionState interface{}) error {
+	ms.mx.RLock()
+	entry, found := ms.entries[sessionToken]
+	if !found || entry.expired(time.Now(), ms.MaxAge) {
+		ms.mx.RUnlock()
+		return ErrStateNotFound
+	}
+	state := entry.state
+	ms.mx.RUnlock()
+
+	err := json.Unmarshal(state, sessionState)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling JSON to struct: %v", err)
+	}
+
+	return nil
+}
+
+// Delete deletes all state data associated with the sessionToken from the store.
+func (ms *MemStore) Delete(sessionToken SessionToken) error {
+	ms.mx.Lock()
+	delete(ms.entries, sessionToken)
+	ms.mx.Unlock()
+	return nil
+}
+
+// Index associates the sessionToken with the given owner,
+// so that all sessions belonging to that owner can be found later.
+func (ms *MemStore) Index(owner string, sessionToken SessionToken) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+
+	index, found := ms.indexes[owner]
+	if !found {
+		index = make(map[SessionToken]bool)
+		ms.indexes[owner] = index
+	}
+	index[sessionToken] = true
+	return nil
+}
+
+// Tokens returns the session tokens associated with the given owner
+// whose session state is still in the store.
+func (ms *MemStore) Tokens(owner string) ([]SessionToken, error) {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+
+	now := time.Now()
+	sessionTokens := []SessionToken{}
+	for sessionToken := range ms.indexes[owner] {
+		entry, found := ms.entries[sessionToken]
+		if found && !entry.expired(now, ms.MaxAge) {
+			sessionTokens = append(sessionTokens, sessionToken)
+		}
+	}
+	return sessionTokens, nil
+}
+
+// DeleteMany atomically deletes all state data associated with
+// the given session tokens of the owner from the store,
+// and removes them from the owner's index.
+func (ms *MemStore) DeleteMany(owner string, sessionTokens ...SessionToken) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+
+	index := ms.indexes[owner]
+	for _, sessionToken := range sessionTokens {
+		delete(ms.entries, sessionToken)
+		delete(index, sessionToken)
+	}
+	return nil
+}
+
+// sweep periodically evicts expired entries from the store.
+func (ms *MemStore) sweep(sweepInterval time.Duration) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		ms.mx.Lock()
+		for sessionToken, entry := range ms.entries {
+			if entry.expired(now, ms.MaxAge) {
+				delete(ms.entries, sessionToken)
+			}
+		}
+		// Prune index entries whose sessions are gone.
+		for owner, index := range ms.indexes {
+			for sessionToken := range index {
+				if _, found := ms.entries[sessionToken]; !found {
+					delete(index, sessionToken)
+				}
+			}
+			if len(index) == 0 {
+				delete(ms.indexes, owner)
+			}
+		}
+		ms.mx.Unlock()
+	}
+}
@@ -3,6 +3,7 @@ package sessions
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -14,10 +15,16 @@ type RedisStore struct {
 	Client *redis.Client
 	// Used for key expiry time on Redis.
 	SessionDuration time.Duration
+	// Absolute maximum age of a session,
+	// no matter how recently it has been used.
+	MaxAge time.Duration
 }
 
 // NewRedisStore constructs a new RedisStore.
-func NewRedisStore(client *redis.Client, sessionDuration time.Duration) *RedisStore {
+func NewRedisStore(client *redis.Client, sessionDuration time.Duration, maxAge time.Duration) *RedisStore {
+	if maxAge <= 0 {
+		panic("Max age must be positive")
+	}
 
 	// Initialize and return a new RedisStore struct.
 	if client == nil {
@@ -31,9 +38,72 @@ func NewRedisStore(client *redis.Client, sessionDuration time.Duration) *RedisSt
 	return &RedisStore{
 		Client:          client,
 		SessionDuration: sessionDuration,
+		MaxAge:          maxAge,
 	}
 }
 
+// saveScript saves session state, marking when the session began
+// unless it is already marked, and makes the state expire
+// no later than its begin mark.
+// State whose begin mark is gone has reached MaxAge,
+// so it isn't saved again.
+// KEYS are the state and begin mark keys, and ARGV holds the state,
+// the session duration and the max age in milliseconds, and the current time.
+var saveScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 and redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("DEL", KEYS[1])
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[4], "PX", ARGV[3], "NX")
+local expiry = tonumber(ARGV[2])
+local beginTTL = redis.call("PTTL", KEYS[2])
+if beginTTL > 0 and beginTTL < expiry then
+	expiry = beginTTL
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", expiry)
+return 1
+`)
+
+// getScript gets session state if its begin mark is still there,
+// and when ARGV[1] is positive, resets its expiry time to that many milliseconds,
+// but no later than the begin mark expires.
+// KEYS are the state and begin mark keys.
+var getScript = redis.NewScript(`
+local beginTTL = redis.call("PTTL", KEYS[2])
+if beginTTL <= 0 then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	return false
+end
+local val = redis.call("GET", KEYS[1])
+if not val then
+	return false
+end
+local expiry = tonumber(ARGV[1])
+if expiry > 0 then
+	if beginTTL < expiry then
+		expiry = beginTTL
+	end
+	redis.call("PEXPIRE", KEYS[1], expiry)
+end
+return val
+`)
+
+// migrateScript marks session state saved before begin marks existed
+// as beginning now, and makes it expire no later than its new begin mark.
+// KEYS are the state and begin mark keys, and ARGV holds
+// the max age in milliseconds and the current time.
+var migrateScript = redis.NewScript(`
+local stateTTL = redis.call("PTTL", KEYS[1])
+if stateTTL == -2 or redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[1])
+if stateTTL < 0 or stateTTL > tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
 // Store implementation
 
 // Save saves the provided "sessionState" and associated sessionToken to the store.
@@ -46,13 +116,22 @@ func (rs *RedisStore) Save(sessionToken SessionToken, sessionState interface{})
 		return fmt.Errorf("error marshalling struct to JSON: %v", err)
 	}
 
-	// Save it in the Redis database,
-	// using "sessionToken.getRedisKey()" for the key.
-	// Return any errors that occur along the way.
-	err = rs.Client.Set(sessionToken.getRedisKey(), j, rs.SessionDuration).Err()
+	// Save it along with the mark of when the session began in one script,
+	// so that the state never outlives the mark, which expires after MaxAge
+	// no matter how often the session is used, and ends the session.
+	saved, err := saveScript.Run(rs.Client,
+		[]string{sessionToken.getRedisKey(), sessionToken.getBeginRedisKey()},
+		j,
+		int64(rs.SessionDuration/time.Millisecond),
+		int64(rs.MaxAge/time.Millisecond),
+		time.Now().Unix(),
+	).Int64()
 	if err != nil {
 		return fmt.Errorf("error saving session state to Redis: %v", err)
 	}
+	if saved == 0 {
+		return ErrStateNotFound
+	}
 
 	return nil
 }
@@ -60,42 +139,36 @@ func (rs *RedisStore) Save(sessionToken SessionToken, sessionState interface{})
 // Get populates "sessionState" with the data previously saved
 // for the given sessionToken.
 func (rs *RedisStore) Get(sessionToken SessionToken, sessionState interface{}) error {
+	// Get the state and reset its expiry time in one script,
+	// so that it doesn't get deleted until the SessionDuration has elapsed,
+	// but never outlives its begin mark.
+	return rs.get(sessionToken, sessionState, rs.SessionDuration)
+}
 
-	// Use the Pipeline feature of the redis
-	// package to do both the get and the reset of the expiry time
-	// in just one network round trip.
-	pipe := rs.Client.Pipeline()
-	defer pipe.Close()
-
-	// get is a string command that retrieves the previously-saved session state
-	// for a given key from Redis.
-	// It is just a command waiting to be executed.
-	get := pipe.Get(sessionToken.getRedisKey())
-
-	// Reset the expiry time, so that it doesn't get deleted until
-	// the SessionDuration has elapsed.
-	expire := pipe.Expire(sessionToken.getRedisKey(), rs.SessionDuration)
-
-	// Execute all previously queued commands using one client-server roundtrip.
-	pipe.Exec()
-
-	// Handle errors that might be returned from
-	// previously executed commands.
+// Peek populates "sessionState" with the data previously saved
+// for the given sessionToken just like Get does,
+// but doesn't reset the expiry time of the session.
+func (rs *RedisStore) Peek(sessionToken SessionToken, sessionState interface{}) error {
+	return rs.get(sessionToken, sessionState, 0)
+}
 
-	// Extract session state data from get command.
-	// If fails to get, return ErrStateNotFound.
-	val, err := get.Bytes()
-	if err != nil {
+// get populates "sessionState" with the data previously saved
+// for the given sessionToken, and resets its expiry time to "expiry"
+// unless it is zero.
+func (rs *RedisStore) get(sessionToken SessionToken, sessionState interface{}, expiry time.Duration) error {
+	val, err := getScript.Run(rs.Client,
+		[]string{sessionToken.getRedisKey(), sessionToken.getBeginRedisKey()},
+		int64(expiry/time.Millisecond),
+	).String()
+	if err == redis.Nil {
 		return ErrStateNotFound
 	}
-
-	err = expire.Err()
 	if err != nil {
-		return fmt.Errorf("error setting expiry time: %v", err)
+		return fmt.Errorf("error getting session state from Redis: %v", err)
 	}
 
 	// Unmarshal it back into the "sessionState" parameter.
-	err = json.Unmarshal(val, sessionState)
+	err = json.Unmarshal([]byte(val), sessionState)
 	if err != nil {
 		return fmt.Errorf("error unmarshalling JSON to struct: %v", err)
 	}
@@ -103,20 +176,140 @@ func (rs *RedisStore) Get(sessionToken SessionToken, sessionState interface{}) e
 	return nil
 }
 
+// MigrateBeginMarks marks every session saved before begin marks existed
+// as beginning now, and returns how many there were.
+// Session state without a begin mark is treated as expired,
+// so this must be run once before such sessions are used.
+func (rs *RedisStore) MigrateBeginMarks() (int, error) {
+	migrated := 0
+	iter := rs.Client.Scan(0, stateKeyPrefix+"*", 100).Iterator()
+	for iter.Next() {
+		sessionToken := SessionToken(strings.TrimPrefix(iter.Val(), stateKeyPrefix))
+		n, err := migrateScript.Run(rs.Client,
+			[]string{sessionToken.getRedisKey(), sessionToken.getBeginRedisKey()},
+			int64(rs.MaxAge/time.Millisecond),
+			time.Now().Unix(),
+		).Int64()
+		if err != nil {
+			return migrated, fmt.Errorf("error migrating session %s: %v", sessionToken, err)
+		}
+		migrated += int(n)
+	}
+	if err := iter.Err(); err != nil {
+		return migrated, fmt.Errorf("error scanning sessions: %v", err)
+	}
+	return migrated, nil
+}
+
 // Delete deletes all state data associated with the sessionToken from the store.
 func (rs *RedisStore) Delete(sessionToken SessionToken) error {
 	// Delete the data stored in Redis for the provided sessionToken.
-	err := rs.Client.Del(sessionToken.getRedisKey()).Err()
+	err := rs.Client.Del(sessionToken.getRedisKey(), sessionToken.getBeginRedisKey()).Err()
 	if err != nil {
 		return fmt.Errorf("error deleting session state: %v", err)
 	}
 	return nil
 }
 
+// Index associates the sessionToken with the given owner,
+// so that all sessions belonging to that owner can be found later.
+func (rs *RedisStore) Index(owner string, sessionToken SessionToken) error {
+	// Each owner has a Redis set containing all of its session tokens.
+	err := rs.Client.SAdd(getIndexRedisKey(owner), sessionToken.String()).Err()
+	if err != nil {
+		return fmt.Errorf("error adding session token to index: %v", err)
+	}
+	return nil
+}
+
+// Tokens returns the session tokens associated with the given owner
+// whose session state is still in the store.
+func (rs *RedisStore) Tokens(owner string) ([]SessionToken, error) {
+	members, err := rs.Client.SMembers(getIndexRedisKey(owner)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error getting session tokens from index: %v", err)
+	}
+
+	// Check which sessions still exist in one network round trip.
+	pipe := rs.Client.Pipeline()
+	defer pipe.Close()
+	exists := make([]*redis.IntCmd, len(members))
+	for i, member := range members {
+		exists[i] = pipe.Exists(SessionToken(member).getRedisKey())
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return nil, fmt.Errorf("error checking session state existence: %v", err)
+	}
+
+	sessionTokens := []SessionToken{}
+	expired := []interface{}{}
+	for i, member := range members {
+		if exists[i].Val() == 0 {
+			expired = append(expired, member)
+			continue
+		}
+		sessionTokens = append(sessionTokens, SessionToken(member))
+	}
+
+	// Sessions expire on their own,
+	// so prune the ones that are gone from the index.
+	if len(expired) > 0 {
+		err = rs.Client.SRem(getIndexRedisKey(owner), expired...).Err()
+		if err != nil {
+			return nil, fmt.Errorf("error pruning expired session tokens from index: %v", err)
+		}
+	}
+
+	return sessionTokens, nil
+}
+
+// DeleteMany atomically deletes all state data associated with
+// the given session tokens of the owner from the store,
+// and removes them from the owner's index.
+func (rs *RedisStore) DeleteMany(owner string, sessionTokens ...SessionToken) error {
+	if len(sessionTokens) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(sessionTokens)*2)
+	members := make([]interface{}, len(sessionTokens))
+	for i, sessionToken := range sessionTokens {
+		keys = append(keys, sessionToken.getRedisKey(), sessionToken.getBeginRedisKey())
+		members[i] = sessionToken.String()
+	}
+
+	// Wrap the commands in a MULTI/EXEC transaction,
+	// so that either all or none of the sessions are deleted.
+	pipe := rs.Client.TxPipeline()
+	defer pipe.Close()
+	pipe.Del(keys...)
+	pipe.SRem(getIndexRedisKey(owner), members...)
+	_, err := pipe.Exec()
+	if err != nil {
+		return fmt.Errorf("error deleting session states: %v", err)
+	}
+
+	return nil
+}
+
+// getIndexRedisKey returns the Redis key of the session index of the owner.
+func getIndexRedisKey(owner string) string {
+	return "sessionIndex:" + owner
+}
+
+// stateKeyPrefix is the prefix of the Redis keys of session state.
+const stateKeyPrefix = "sessionToken:"
+
 // getRedisKey() returns the Redis key to use for the sessionToken.
 func (sessionToken SessionToken) getRedisKey() string {
 	// Convert the SessionToken to a string and add the prefix "sessionToken:" to keep
 	// SessionToken keys separate from other keys that might end up in this
 	// Redis instance.
-	return "sessionToken:" + sessionToken.String()
+	return stateKeyPrefix + sessionToken.String()
+}
+
+// getBeginRedisKey returns the Redis key marking when the session began.
+func (sessionToken SessionToken) getBeginRedisKey() string {
+	return "sessionBegin:" + sessionToken.String()
 }
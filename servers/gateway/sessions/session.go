@@ -4,26 +4,24 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 )
 
 const headerAuthorization = "Authorization"
-const paramAuthorization = "auth"
 const schemeBearer = "Bearer "
 
-// ErrNoSessionToken is used when no session token was found in the Authorization header.
-var ErrNoSessionToken = errors.New("no session token found in " + headerAuthorization + " header")
+// ErrNoSessionToken is used when no session token was found in the request.
+var ErrNoSessionToken = errors.New("no session token found in request")
 
 // ErrInvalidScheme is used when the authorization scheme is not supported.
 var ErrInvalidScheme = errors.New("authorization scheme not supported")
 
-// BeginSession creates a new session token, saves the "sessionState" to the store, adds an
-// Authorization header to the response with the created session token,
+// BeginSession creates a new session token, saves the "sessionState" to the store,
+// sends the created session token to the client using "transport",
 // and returns the new session token.
-func BeginSession(signingKey string, store Store, sessionState interface{}, w http.ResponseWriter) (SessionToken, error) {
+func BeginSession(keyring *Keyring, transport Transport, store Store, sessionState interface{}, w http.ResponseWriter) (SessionToken, error) {
 
 	// Create a new session token.
-	sessionToken, err := NewSessionToken(signingKey)
+	sessionToken, err := NewSessionToken(keyring)
 	if err != nil {
 		return InvalidSessionToken, fmt.Errorf("error creating a new session ID: %v", err)
 	}
@@ -34,40 +32,28 @@ func BeginSession(signingKey string, store Store, sessionState interface{}, w ht
 		return InvalidSessionToken, fmt.Errorf("error saving session state: %v", err)
 	}
 
-	// Add a header to the ResponseWriter that looks like this:
-	// "Authorization: Bearer <SessionToken>"
-	// where "<SessionToken>" is replaced with the newly-created session token.
-	w.Header().Add(headerAuthorization, schemeBearer+sessionToken.String())
+	// Send the session token to the client,
+	// for example in the Authorization header.
+	err = transport.SetToken(w, sessionToken)
+	if err != nil {
+		return InvalidSessionToken, fmt.Errorf("error sending session token: %v", err)
+	}
 
 	return sessionToken, nil
 }
 
-// GetSessionToken extracts and validates the session token from the request headers.
-func GetSessionToken(r *http.Request, signingKey string) (SessionToken, error) {
+// GetSessionToken extracts the session token from the request using "transport",
+// and validates it.
+func GetSessionToken(r *http.Request, keyring *Keyring, transport Transport) (SessionToken, error) {
 
-	// Get the value of the Authorization header.
-	val := r.Header.Get(headerAuthorization)
-
-	// If no Authorization header is present, get the "auth" query string parameter.
-	if len(val) == 0 {
-		val = r.URL.Query().Get("auth")
-		if len(val) == 0 {
-			return InvalidSessionToken, ErrNoSessionToken
-		}
-	}
-
-	// The value of a valid Authorization header should look like this:
-	// "Bearer <SessionToken>"
-	// If Bearer is missing, return InvalidSessionToken and ErrInvalidScheme.
-	if !strings.HasPrefix(val, schemeBearer) {
-		return InvalidSessionToken, ErrInvalidScheme
+	// Get the unverified session token from the request.
+	unverifiedSessionToken, err := transport.GetToken(r)
+	if err != nil {
+		return InvalidSessionToken, err
 	}
 
-	// Get the session token part.
-	unverifiedSessionToken := strings.TrimPrefix(val, schemeBearer)
-
-	// Validate session token from the request header.
-	sessionToken, err := ValidateToken(unverifiedSessionToken, signingKey)
+	// Validate session token from the request.
+	sessionToken, err := ValidateToken(unverifiedSessionToken, keyring)
 	if err != nil {
 		return InvalidSessionToken, fmt.Errorf("error validating session token received from request: %v", err)
 	}
@@ -78,10 +64,10 @@ func GetSessionToken(r *http.Request, signingKey string) (SessionToken, error) {
 // GetState extracts the session token from the request,
 // gets the associated state from the provided store into
 // the "sessionState" parameter, and returns the session token.
-func GetState(r *http.Request, signingKey string, store Store, sessionState interface{}) (SessionToken, error) {
+func GetState(r *http.Request, keyring *Keyring, transport Transport, store Store, sessionState interface{}) (SessionToken, error) {
 
 	// Get the session token from the request.
-	sessionToken, err := GetSessionToken(r, signingKey)
+	sessionToken, err := GetSessionToken(r, keyring, transport)
 	if err != nil {
 		return sessionToken, fmt.Errorf("error getting session token: %v", err)
 	}
@@ -98,10 +84,10 @@ func GetState(r *http.Request, signingKey string, store Store, sessionState inte
 // EndSession extracts the session token from the request,
 // and deletes the associated data in the provided store, returning
 // the extracted session token.
-func EndSession(r *http.Request, signingKey string, store Store) (SessionToken, error) {
+func EndSession(r *http.Request, keyring *Keyring, transport Transport, store Store) (SessionToken, error) {
 
 	// Get the session token from the request.
-	sessionToken, err := GetSessionToken(r, signingKey)
+	sessionToken, err := GetSessionToken(r, keyring, transport)
 	if err != nil {
 		return sessionToken, fmt.Errorf("error getting session token: %v", err)
 	}
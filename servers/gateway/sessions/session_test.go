@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func FuzzGetSessionToken(f *testing.F) {
+	keyring, err := NewKeyring("current")
+	if err != nil {
+		f.Fatalf("error creating keyring: %v", err)
+	}
+
+	sessionToken, err := NewSessionToken(keyring)
+	if err != nil {
+		f.Fatalf("error creating session token: %v", err)
+	}
+
+	f.Add(schemeBearer + sessionToken.String())
+	f.Add(schemeBearer)
+	f.Add(schemeBearer + "AAAA")
+	f.Add("Basic " + sessionToken.String())
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, header string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(header) != 0 {
+			r.Header.Set(headerAuthorization, header)
+		}
+
+		// GetSessionToken must never panic,
+		// and must only return session tokens that validate.
+		validToken, err := GetSessionToken(r, keyring, &HeaderTransport{})
+		if err != nil {
+			if validToken != InvalidSessionToken {
+				t.Errorf("expected InvalidSessionToken on error but got %q", validToken)
+			}
+			return
+		}
+		if _, err := ValidateToken(validToken.String(), keyring); err != nil {
+			t.Errorf("GetSessionToken returned a session token that doesn't validate: %v", err)
+		}
+	})
+}
+
+func TestGetSessionTokenIgnoresQueryString(t *testing.T) {
+	keyring, err := NewKeyring("current")
+	if err != nil {
+		t.Fatalf("error creating keyring: %v", err)
+	}
+
+	sessionToken, err := NewSessionToken(keyring)
+	if err != nil {
+		t.Fatalf("error creating session token: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/?auth=Bearer+"+sessionToken.String(), nil)
+	if _, err := GetSessionToken(r, keyring, &HeaderTransport{}); err != ErrNoSessionToken {
+		t.Errorf("expected ErrNoSessionToken for session token in query string, but got %v", err)
+	}
+}
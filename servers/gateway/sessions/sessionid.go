@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 )
@@ -19,6 +20,10 @@ const idLength = 32
 // (ID portion plus signature).
 const signedLength = idLength + sha256.Size
 
+// encodedLength is the full length of the base64 encoded
+// signed session ID (session token).
+var encodedLength = base64.URLEncoding.EncodedLen(signedLength)
+
 // SessionToken represents a valid, digitally-signed session ID.
 // This is a base64 URL encoded string created from a byte slice
 // where the first "idLength" bytes are crytographically random
@@ -30,18 +35,13 @@ const signedLength = idLength + sha256.Size
 // +-----------------------------------------------------+
 type SessionToken string
 
-// ErrInvalidID is returned when an invalid session id is passed to ValidateID().
+// ErrInvalidToken is returned when an invalid session token is passed to ValidateToken().
 var ErrInvalidToken = errors.New("Invalid session token")
 
 // NewSessionToken creates and returns a new digitally-signed session ID (session token),
-// using "signingKey" as the HMAC signing key. An error is returned only
+// using the current key of "keyring" as the HMAC signing key. An error is returned only
 // if there was an error generating random bytes for the session ID.
-func NewSessionToken(signingKey string) (SessionToken, error) {
-	// If "signingKey" is zero-length, return InvalidSessionToken
-	// and an error indicating that it may not be empty.
-	if len(signingKey) == 0 {
-		return InvalidSessionToken, fmt.Errorf("signing key cannot be zero")
-	}
+func NewSessionToken(keyring *Keyring) (SessionToken, error) {
 
 	// Create a slice of bytes to store decoded session token.
 	resultBytes := make([]byte, signedLength)
@@ -57,14 +57,8 @@ func NewSessionToken(signingKey string) (SessionToken, error) {
 	// Copy it to resultBytes.
 	copy(resultBytes, sessionID)
 
-	// Create a new HMAC hasher.
-	h := hmac.New(sha256.New, []byte(signingKey))
-
-	// Generate hashed session ID.
-	h.Write(sessionID)
-
 	// Calculate the HMAC signature.
-	sig := h.Sum(nil)
+	sig := sign(sessionID, keyring.Current())
 
 	// Copy the HMAC signature to resultBytes.
 	copy(resultBytes[idLength:], sig)
@@ -76,37 +70,59 @@ func NewSessionToken(signingKey string) (SessionToken, error) {
 }
 
 // ValidateToken validates the string in the "sessionToken" parameter
-// using the "signingKey" as the HMAC signing key
+// against every key of "keyring" as the HMAC signing key
 // and returns an error if invalid, or a SessionToken if valid.
-func ValidateToken(sessionToken string, signingKey string) (SessionToken, error) {
+func ValidateToken(sessionToken string, keyring *Keyring) (SessionToken, error) {
+
+	// A valid session token always has the exact length
+	// of a base64 encoded signed session ID.
+	if len(sessionToken) != encodedLength {
+		return InvalidSessionToken, ErrInvalidToken
+	}
 
 	// Base64 decode the session token to a slice of bytes.
 	// dst represents the decoded session token.
-	dst, err := base64.URLEncoding.DecodeString(sessionToken)
-	if err != nil {
-		return InvalidSessionToken, fmt.Errorf("error decoding provided session token: %v", err)
+	// Strict decoding rejects non-canonical encodings,
+	// so that each signed session ID has exactly one valid token.
+	dst, err := base64.URLEncoding.Strict().DecodeString(sessionToken)
+	if err != nil || len(dst) != signedLength {
+		return InvalidSessionToken, ErrInvalidToken
 	}
 
 	// Get old session ID and its signature.
 	sessionID := dst[:idLength]
 	oldSig := dst[idLength:]
 
+	// If the old HMAC signature matches a new one
+	// calculated with any of the keys, this session token is valid.
+	for _, signingKey := range keyring.Keys() {
+		if hmac.Equal(oldSig, sign(sessionID, signingKey)) {
+			return SessionToken(sessionToken), nil
+		}
+	}
+
+	return InvalidSessionToken, ErrInvalidToken
+}
+
+// sign calculates the HMAC signature of the session ID
+// using "signingKey" as the HMAC signing key.
+func sign(sessionID []byte, signingKey string) []byte {
 	// Create a new HMAC hasher.
 	h := hmac.New(sha256.New, []byte(signingKey))
 
-	// Generate a new hashed session ID.
+	// Generate hashed session ID.
 	h.Write(sessionID)
 
-	// Calculate a new HMAC signature.
-	newSig := h.Sum(nil)
-
-	// If the old HMAC signature and new one are not equal,
-	// it means this session token is invalid.
-	if !hmac.Equal(oldSig, newSig) {
-		return InvalidSessionToken, ErrInvalidToken
-	}
+	// Calculate the HMAC signature.
+	return h.Sum(nil)
+}
 
-	return SessionToken(sessionToken), nil
+// ID returns a public identifier of the sessionToken.
+// Unlike the session token itself, the ID can be shown to clients,
+// because it can't be used to authenticate.
+func (sessionToken SessionToken) ID() string {
+	sum := sha256.Sum256([]byte(sessionToken))
+	return hex.EncodeToString(sum[:idLength/2])
 }
 
 // String returns a string representation of the sessionToken.
@@ -0,0 +1,74 @@
+package sessions
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzValidateToken(f *testing.F) {
+	keyring, err := NewKeyring("current", "previous")
+	if err != nil {
+		f.Fatalf("error creating keyring: %v", err)
+	}
+
+	sessionToken, err := NewSessionToken(keyring)
+	if err != nil {
+		f.Fatalf("error creating session token: %v", err)
+	}
+
+	f.Add(sessionToken.String())
+	f.Add(sessionToken.String() + "AAAA")
+	f.Add(sessionToken.String()[:idLength])
+	f.Add(strings.TrimRight(sessionToken.String(), "="))
+	f.Add("")
+	f.Add("AAAA")
+	f.Add("not base64!")
+
+	f.Fuzz(func(t *testing.T, unverifiedSessionToken string) {
+		// ValidateToken must never panic,
+		// and must only accept tokens of the exact signed length.
+		validToken, err := ValidateToken(unverifiedSessionToken, keyring)
+		if err != nil {
+			if err != ErrInvalidToken {
+				t.Errorf("expected ErrInvalidToken but got %v", err)
+			}
+			if validToken != InvalidSessionToken {
+				t.Errorf("expected InvalidSessionToken on error but got %q", validToken)
+			}
+			return
+		}
+		if len(unverifiedSessionToken) != encodedLength {
+			t.Errorf("accepted session token of length %d, expected %d", len(unverifiedSessionToken), encodedLength)
+		}
+		if validToken.String() != unverifiedSessionToken {
+			t.Errorf("expected %q but got %q", unverifiedSessionToken, validToken)
+		}
+	})
+}
+
+func TestValidateTokenKeyring(t *testing.T) {
+	oldKeyring, err := NewKeyring("previous")
+	if err != nil {
+		t.Fatalf("error creating keyring: %v", err)
+	}
+	rotatedKeyring, err := NewKeyring("current", "previous")
+	if err != nil {
+		t.Fatalf("error creating keyring: %v", err)
+	}
+	otherKeyring, err := NewKeyring("other")
+	if err != nil {
+		t.Fatalf("error creating keyring: %v", err)
+	}
+
+	sessionToken, err := NewSessionToken(oldKeyring)
+	if err != nil {
+		t.Fatalf("error creating session token: %v", err)
+	}
+
+	if _, err := ValidateToken(sessionToken.String(), rotatedKeyring); err != nil {
+		t.Errorf("expected token signed with a previous key to be valid, but got %v", err)
+	}
+	if _, err := ValidateToken(sessionToken.String(), otherKeyring); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken for unknown key, but got %v", err)
+	}
+}
@@ -23,6 +23,24 @@ type Store interface {
 	// for the given sessionToken.
 	Get(sessionToken SessionToken, sessionState interface{}) error
 
+	// Peek populates "sessionState" with the data previously saved
+	// for the given sessionToken just like Get does,
+	// but doesn't reset the expiry time of the session.
+	Peek(sessionToken SessionToken, sessionState interface{}) error
+
 	// Delete deletes all state data associated with the sessionToken from the store.
 	Delete(sessionToken SessionToken) error
+
+	// Index associates the sessionToken with the given owner,
+	// so that all sessions belonging to that owner can be found later.
+	Index(owner string, sessionToken SessionToken) error
+
+	// Tokens returns the session tokens associated with the given owner
+	// whose session state is still in the store.
+	Tokens(owner string) ([]SessionToken, error)
+
+	// DeleteMany atomically deletes all state data associated with
+	// the given session tokens of the owner from the store,
+	// and removes them from the owner's index.
+	DeleteMany(owner string, sessionTokens ...SessionToken) error
 }
@@ -0,0 +1,181 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const headerCSRFToken = "X-CSRF-Token"
+
+// csrfTokenLength is the length of the random bytes of a CSRF token.
+const csrfTokenLength = 32
+
+// ErrInvalidCSRFToken is used when a state-changing request authenticated
+// by cookie doesn't carry a CSRF token matching the CSRF cookie.
+var ErrInvalidCSRFToken = errors.New("CSRF token missing or not matching " + headerCSRFToken + " header")
+
+// Transport carries session tokens between the server and clients.
+// This is an abstract interface that can be implemented
+// for different ways of transporting session tokens,
+// such as the Authorization header or cookies.
+type Transport interface {
+	// SetToken sends the session token to the client.
+	SetToken(w http.ResponseWriter, sessionToken SessionToken) error
+
+	// GetToken extracts the unverified session token from the request.
+	GetToken(r *http.Request) (string, error)
+
+	// ClearToken tells the client to forget its session token.
+	ClearToken(w http.ResponseWriter)
+}
+
+// HeaderTransport implements Transport using the Authorization header.
+// Session tokens are never read from the query string,
+// so that they don't end up in access logs.
+type HeaderTransport struct{}
+
+// SetToken adds an Authorization header to the response with the session token.
+func (ht *HeaderTransport) SetToken(w http.ResponseWriter, sessionToken SessionToken) error {
+	// Add a header to the ResponseWriter that looks like this:
+	// "Authorization: Bearer <SessionToken>"
+	// where "<SessionToken>" is replaced with the newly-created session token.
+	w.Header().Add(headerAuthorization, schemeBearer+sessionToken.String())
+	return nil
+}
+
+// GetToken extracts the unverified session token from the Authorization header.
+func (ht *HeaderTransport) GetToken(r *http.Request) (string, error) {
+	// Get the value of the Authorization header.
+	val := r.Header.Get(headerAuthorization)
+	if len(val) == 0 {
+		return "", ErrNoSessionToken
+	}
+
+	// The value of a valid Authorization header should look like this:
+	// "Bearer <SessionToken>"
+	// If Bearer is missing, return ErrInvalidScheme.
+	if !strings.HasPrefix(val, schemeBearer) {
+		return "", ErrInvalidScheme
+	}
+
+	// Get the session token part.
+	return strings.TrimPrefix(val, schemeBearer), nil
+}
+
+// ClearToken does nothing, because clients
+// are responsible for forgetting the Authorization header.
+func (ht *HeaderTransport) ClearToken(w http.ResponseWriter) {}
+
+// CookieTransport implements Transport using a Secure, HttpOnly, SameSite cookie,
+// so that session tokens are never exposed to scripts or written to access logs.
+// State-changing requests are protected against CSRF with the double-submit pattern:
+// a second cookie readable by scripts holds a random CSRF token,
+// which clients must echo in the X-CSRF-Token header.
+// The CSRF token is also sent in the X-CSRF-Token response header,
+// for clients served from another host than the API.
+type CookieTransport struct {
+	// Name of the cookie holding the session token.
+	CookieName string
+	// Name of the cookie holding the CSRF token.
+	CSRFCookieName string
+	// Domain of the CSRF cookie, such as "zicodeng.me",
+	// so that scripts on other hosts of that domain can read it.
+	// If empty, the cookie is only visible to the API host.
+	CSRFCookieDomain string
+}
+
+// NewCookieTransport constructs a new CookieTransport.
+func NewCookieTransport(cookieName string, csrfCookieName string, csrfCookieDomain string) *CookieTransport {
+	if len(cookieName) == 0 || len(csrfCookieName) == 0 {
+		panic("Cookie name has length of zero")
+	}
+	return &CookieTransport{cookieName, csrfCookieName, csrfCookieDomain}
+}
+
+// SetToken sets the session token cookie and a new CSRF token cookie.
+func (ct *CookieTransport) SetToken(w http.ResponseWriter, sessionToken SessionToken) error {
+	csrfBytes := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(csrfBytes); err != nil {
+		return fmt.Errorf("error generating cryptographically random bytes: %v", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ct.CookieName,
+		Value:    sessionToken.String(),
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Scripts must be able to read the CSRF token,
+	// so this cookie is not HttpOnly.
+	csrfToken := base64.URLEncoding.EncodeToString(csrfBytes)
+	http.SetCookie(w, &http.Cookie{
+		Name:     ct.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		Domain:   ct.CSRFCookieDomain,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set(headerCSRFToken, csrfToken)
+
+	return nil
+}
+
+// GetToken extracts the unverified session token from the session token cookie.
+// For state-changing methods, the X-CSRF-Token header must match the CSRF cookie.
+func (ct *CookieTransport) GetToken(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(ct.CookieName)
+	if err != nil || len(cookie.Value) == 0 {
+		return "", ErrNoSessionToken
+	}
+
+	if isStateChanging(r.Method) {
+		csrfCookie, err := r.Cookie(ct.CSRFCookieName)
+		if err != nil || len(csrfCookie.Value) == 0 {
+			return "", ErrInvalidCSRFToken
+		}
+		csrfHeader := r.Header.Get(headerCSRFToken)
+		if subtle.ConstantTimeCompare([]byte(csrfHeader), []byte(csrfCookie.Value)) != 1 {
+			return "", ErrInvalidCSRFToken
+		}
+	}
+
+	return cookie.Value, nil
+}
+
+// ClearToken expires the session token and CSRF token cookies.
+func (ct *CookieTransport) ClearToken(w http.ResponseWriter) {
+	domains := map[string]string{
+		ct.CookieName:     "",
+		ct.CSRFCookieName: ct.CSRFCookieDomain,
+	}
+	for name, domain := range domains {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			Domain:   domain,
+			MaxAge:   -1,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// isStateChanging returns whether requests with the method can change state.
+func isStateChanging(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,137 @@
+// Package totp implements time-based one-time passwords
+// as described in RFC 6238, compatible with common authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// secretLength is the number of random bytes in a secret,
+// as recommended by RFC 4226 for HMAC-SHA1.
+const secretLength = 20
+
+// period is how long each code is valid.
+const period = 30
+
+// digits is the number of digits in a code.
+const digits = 6
+
+// skew is the number of periods before and after the current one
+// whose codes are also accepted, to allow for clock drift.
+const skew = 1
+
+// encoding is the base32 encoding secrets are shared in.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, encoded in base32.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretLength)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("error generating random secret: %v", err)
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns the otpauth URI of the secret,
+// which authenticator apps can import, usually from a QR code.
+func URI(issuer string, account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(digits))
+	params.Set("period", fmt.Sprint(period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Code returns the code of the secret at time "t".
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate returns whether "code" is valid for the secret at time "t",
+// along with the counter of the period it belongs to.
+// Callers should only accept codes whose counter is later than
+// that of the last code they accepted, so that codes can't be replayed
+// while they're still valid.
+func Validate(secret string, code string, t time.Time) (uint64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != digits {
+		return 0, false
+	}
+
+	matched := uint64(0)
+	valid := false
+	c := counter(t)
+	for i := -skew; i <= skew; i++ {
+		// Check every period, so that the time taken
+		// doesn't reveal which one matched.
+		if subtle.ConstantTimeCompare([]byte(code), []byte(codeAt(key, c, i))) == 1 {
+			matched = uint64(int64(c) + int64(i))
+			valid = true
+		}
+	}
+	return matched, valid
+}
+
+// decodeSecret decodes a base32 secret,
+// ignoring case, spaces and padding as authenticator apps do.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding secret: %v", err)
+	}
+	return key, nil
+}
+
+// counter returns the number of periods since the Unix epoch at time "t".
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / period
+}
+
+// codeAt returns the code of the period "offset" periods away from "c".
+func codeAt(key []byte, c uint64, offset int) string {
+	return code(key, uint64(int64(c)+int64(offset)))
+}
+
+// code computes the HOTP value of the counter as described in RFC 4226.
+func code(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
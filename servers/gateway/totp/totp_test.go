@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors,
+// encoded in base32 the way secrets are shared.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// rfcVectors are the SHA-1 test vectors of RFC 6238, appendix B.
+// The 8-digit codes are truncated to the last 6 digits,
+// which is what RFC 4226 truncation yields for 6-digit codes.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeMatchesRFCVectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		expected := v.code[len(v.code)-digits:]
+		code, err := Code(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatalf("error computing code at %d: %v", v.unix, err)
+		}
+		if code != expected {
+			t.Errorf("expected code %s at %d but got %s", expected, v.unix, code)
+		}
+	}
+}
+
+func TestValidateMatchesRFCVectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		code := v.code[len(v.code)-digits:]
+		c, valid := Validate(rfcSecret, code, time.Unix(v.unix, 0))
+		if !valid {
+			t.Errorf("expected code %s to be valid at %d", code, v.unix)
+			continue
+		}
+		if expected := uint64(v.unix) / period; c != expected {
+			t.Errorf("expected counter %d for code %s but got %d", expected, code, c)
+		}
+	}
+}
+
+func TestValidateReturnsCounterOfSkewedCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for offset := -skew; offset <= skew; offset++ {
+		at := now.Add(time.Duration(offset*period) * time.Second)
+		code, err := Code(rfcSecret, at)
+		if err != nil {
+			t.Fatalf("error computing code: %v", err)
+		}
+		c, valid := Validate(rfcSecret, code, now)
+		if !valid {
+			t.Errorf("expected code %d periods away to be valid", offset)
+			continue
+		}
+		if c != counter(at) {
+			t.Errorf("expected counter %d for code %d periods away but got %d", counter(at), offset, c)
+		}
+	}
+
+	// Codes outside of the skew are rejected.
+	code, err := Code(rfcSecret, now.Add(time.Duration((skew+1)*period)*time.Second))
+	if err != nil {
+		t.Fatalf("error computing code: %v", err)
+	}
+	if _, valid := Validate(rfcSecret, code, now); valid {
+		t.Errorf("expected code %d periods away to be rejected", skew+1)
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870822", "abcdef"} {
+		if _, valid := Validate(rfcSecret, code, now); valid {
+			t.Errorf("expected code %q to be rejected", code)
+		}
+	}
+	if _, valid := Validate("not base32!", "287082", now); valid {
+		t.Errorf("expected code with malformed secret to be rejected")
+	}
+	if _, valid := Validate(rfcSecret, "287 082", now); !valid {
+		t.Errorf("expected code with spaces to be valid")
+	}
+}
@@ -9,4 +9,11 @@ export MONGO_ADDR=localhost:27017
 export MQ_ADDR=localhost:5672
 export DB_NAME="app"
 
+# The key this microservice signs its announcements with is a deployment secret
+# shared with the gateway, so it is never kept in the repository.
+if [ -z "$SERVICE_KEY" ]; then
+    echo 'Please set SERVICE_KEY environment variable'
+    exit 1
+fi
+
 ./node_modules/.bin/nodemon index.js
\ No newline at end of file
@@ -23,6 +23,14 @@ const VisitorStore = require('./models/visitors/visitor-store');
 const redis = require('redis');
 const redisAddr = process.env.REDIS_ADDR || 'localhost';
 
+const crypto = require('crypto');
+// Key shared with the gateway to sign our announcements.
+const serviceKey = process.env.SERVICE_KEY;
+if (!serviceKey) {
+    console.log('Please set SERVICE_KEY environment variable');
+    process.exit(1);
+}
+
 const amqp = require('amqplib');
 // Queue name needs to be the same queue name that our gateway is listening to.
 const visitorQueue = 'VisitorQueue';
@@ -46,15 +54,26 @@ const OfficeHandler = require('./handlers/office');
         const heartBeat = 10;
         const visitorMicroservice = {
             name: 'Visitor',
-            pathPattern: '/v1/offices/?',
+            pathPattern: '^/v1/offices',
             address: serverAddr,
-            heartbeat: heartBeat
+            heartbeat: heartBeat,
+            // Send each admin to the same instance,
+            // so that their offices' search tries stay cached there.
+            balancer: 'consistent-hash'
         };
         const redisChannel = 'Microservices';
         setInterval(() => {
+            // Sign "<timestamp>.<payload>",
+            // so that the gateway can tell the announcement is ours and recent.
+            const payload = JSON.stringify(visitorMicroservice);
+            const timestamp = Math.floor(Date.now() / sec);
+            const signature = crypto
+                .createHmac('sha256', serviceKey)
+                .update(`${timestamp}.${payload}`)
+                .digest('hex');
             publisher.publish(
                 redisChannel,
-                JSON.stringify(visitorMicroservice)
+                JSON.stringify({ payload, timestamp, signature })
             );
         }, heartBeat * sec);
 
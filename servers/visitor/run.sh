@@ -17,6 +17,13 @@ export MQ_ADDR=$MQ_CONTAINER:5672
 export DB_NAME="app"
 export APP_NETWORK=appnet
 
+# The key this microservice signs its announcements with is a deployment secret
+# shared with the gateway, so it is never kept in the repository.
+if [ -z "$SERVICE_KEY" ]; then
+    echo 'Please set SERVICE_KEY environment variable'
+    exit 1
+fi
+
 docker pull zicodeng/$VISITOR_CONTAINER
 
 if [ "$(docker ps -aq --filter name=$VISITOR_CONTAINER)" ]; then
@@ -41,6 +48,7 @@ docker run \
 -e MONGO_ADDR=mongo-server:27017 \
 -e REDIS_ADDR=$REDIS_ADDR \
 -e DB_NAME=$DB_NAME \
+-e SERVICE_KEY \
 --name $VISITOR_CONTAINER \
 --network $APP_NETWORK \
 --restart unless-stopped \